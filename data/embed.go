@@ -0,0 +1,9 @@
+// Package data holds the default graphs, compiled into the binary.
+package data
+
+import "embed"
+
+// Graphs contains the default graph files, in the "graphs" folder.
+//
+//go:embed graphs/*.xml
+var Graphs embed.FS
@@ -0,0 +1,115 @@
+package graph
+
+// Check and normalize arg values.
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The types of arg values.
+const (
+	ArgString      = "string"
+	ArgPath        = "path"
+	ArgExistingDir = "existing-dir"
+	ArgInt         = "int"
+	ArgBool        = "bool"
+	ArgDuration    = "duration"
+	ArgEnum        = "enum"
+)
+
+// Name answers the name of the arg, which is its element name.
+func (a *Arg) Name() string {
+	return a.XMLName.Local
+}
+
+// checkType answers an error if the arg type is unknown.
+func (a *Arg) checkType() error {
+	switch a.Type {
+	case "", ArgString, ArgPath, ArgExistingDir, ArgInt, ArgBool, ArgDuration:
+		return nil
+	case ArgEnum:
+		if len(a.choices()) <= 0 {
+			return errors.New("Arg \"" + a.Name() + "\" is an enum without any choices")
+		}
+		return nil
+	}
+	return errors.New("Arg \"" + a.Name() + "\" has unknown type \"" + a.Type + "\"")
+}
+
+// checkValue answers an error if the arg is required but missing, or if
+// the value doesn't match the type. Paths are cleaned, with a leading ~
+// replaced by the home folder.
+func (a *Arg) checkValue() error {
+	if a.Value == "" {
+		if a.Required {
+			msg := "Missing required arg \"" + a.Name() + "\" (-" + a.Name() + "=value)"
+			if a.Usage != "" {
+				msg += ": " + a.Usage
+			}
+			return errors.New(msg)
+		}
+		return nil
+	}
+
+	var err error
+	switch a.Type {
+	case ArgPath, ArgExistingDir:
+		a.Value = cleanPath(a.Value)
+		if a.Type == ArgExistingDir {
+			info, serr := os.Stat(a.Value)
+			if serr != nil {
+				return errors.New("Arg \"" + a.Name() + "\" folder \"" + a.Value + "\" does not exist")
+			} else if !info.IsDir() {
+				return errors.New("Arg \"" + a.Name() + "\" path \"" + a.Value + "\" is not a folder")
+			}
+		}
+	case ArgInt:
+		_, err = strconv.Atoi(a.Value)
+	case ArgBool:
+		_, err = strconv.ParseBool(a.Value)
+	case ArgDuration:
+		_, err = time.ParseDuration(a.Value)
+	case ArgEnum:
+		choices := a.choices()
+		for _, c := range choices {
+			if c == a.Value {
+				return nil
+			}
+		}
+		return errors.New("Arg \"" + a.Name() + "\" must be one of " + strings.Join(choices, ", ") + ", not \"" + a.Value + "\"")
+	}
+	if err != nil {
+		return errors.New("Arg \"" + a.Name() + "\" must be a " + a.Type + ", not \"" + a.Value + "\"")
+	}
+	return nil
+}
+
+func (a *Arg) choices() []string {
+	var choices []string
+	for _, c := range strings.Split(a.Choices, ",") {
+		c = strings.TrimSpace(c)
+		if c != "" {
+			choices = append(choices, c)
+		}
+	}
+	return choices
+}
+
+// cleanPath answers the path with a leading ~ expanded, made absolute so
+// it names the same file no matter which folder a node runs in.
+func cleanPath(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") || strings.HasPrefix(p, "~"+string(filepath.Separator)) {
+		if home, err := os.UserHomeDir(); err == nil {
+			p = home + p[1:]
+		}
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return filepath.Clean(p)
+}
@@ -0,0 +1,74 @@
+package graph
+
+// Variables that are available to every graph without being declared.
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// envPrefix starts a variable that's replaced by an environment variable, i.e. ${env:GOPATH}.
+const envPrefix = "env:"
+
+// builtinNames are the variables supplied by builtins, other than environment variables.
+var builtinNames = []string{"os", "arch", "exe_ext", "path_sep", "graph_dir", "cwd", "home"}
+
+// builtins supplies the built-in variables.
+type builtins struct {
+	// The graph file the variable is used in.
+	file string
+}
+
+// lookup() answers the value of the built-in variable.
+func (b builtins) lookup(name string) (string, bool) {
+	if strings.HasPrefix(name, envPrefix) {
+		return os.LookupEnv(strings.TrimPrefix(name, envPrefix))
+	}
+	switch name {
+	case "os":
+		return runtime.GOOS, true
+	case "arch":
+		return runtime.GOARCH, true
+	case "exe_ext":
+		if runtime.GOOS == "windows" {
+			return ".exe", true
+		}
+		return "", true
+	case "path_sep":
+		return string(filepath.Separator), true
+	case "graph_dir":
+		// Built-in graphs aren't in a folder.
+		if b.file == "" || isEmbedded(b.file) {
+			return "", false
+		}
+		dir, err := filepath.Abs(filepath.Dir(b.file))
+		return dir, err == nil
+	case "cwd":
+		dir, err := os.Getwd()
+		return dir, err == nil
+	case "home":
+		dir, err := os.UserHomeDir()
+		return dir, err == nil
+	}
+	return "", false
+}
+
+// isBuiltin() answers true if the name is a built-in variable.
+func isBuiltin(name string) bool {
+	return strings.HasPrefix(name, envPrefix) || containsString(builtinNames, name)
+}
+
+// undefinedMsg answers the error for a variable that couldn't be replaced.
+func undefinedMsg(name, file string) string {
+	switch {
+	case strings.HasPrefix(name, envPrefix):
+		return "Environment variable \"" + strings.TrimPrefix(name, envPrefix) + "\" is not set (used as ${" + name + "})"
+	case name == "graph_dir" && isEmbedded(file):
+		return "Undefined variable ${graph_dir}: built-in graphs aren't in a folder"
+	case isBuiltin(name):
+		return "Built-in variable ${" + name + "} is not available"
+	}
+	return "Undefined variable ${" + name + "}"
+}
@@ -0,0 +1,115 @@
+package graph
+
+// Access the default graphs compiled into the binary.
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hackborn/ghost/data"
+)
+
+const (
+	// Filenames of embedded graphs start with this prefix.
+	embeddedPrefix = "embedded:"
+	// The folder in data.Graphs that contains the graphs.
+	embeddedDir = "graphs"
+)
+
+func isEmbedded(filename string) bool {
+	return strings.HasPrefix(filename, embeddedPrefix)
+}
+
+// EmbeddedNames answers the names of the graphs compiled into the binary.
+func EmbeddedNames() []string {
+	var names []string
+	entries, _ := fs.ReadDir(data.Graphs, embeddedDir)
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, formatName(e.Name()))
+		}
+	}
+	return names
+}
+
+// findEmbedded answers the filename of the embedded graph with the given name.
+func findEmbedded(n string) (string, error) {
+	entries, _ := fs.ReadDir(data.Graphs, embeddedDir)
+	for _, e := range entries {
+		if !e.IsDir() && formatName(e.Name()) == n {
+			return embeddedPrefix + e.Name(), nil
+		}
+	}
+	return "", errors.New("No match")
+}
+
+// ExportEmbedded writes the embedded graph with the given name to the file,
+// which must not already exist.
+func ExportEmbedded(n, filename string) error {
+	src, err := findEmbedded(strings.ToLower(n))
+	if err != nil {
+		return errors.New("No built-in graph named \"" + n + "\"")
+	}
+	r, err := openGraphFile(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	cerr := w.Close()
+	if err != nil {
+		return err
+	}
+	return cerr
+}
+
+// openGraphFile opens the graph file, which can be embedded.
+func openGraphFile(filename string) (io.ReadCloser, error) {
+	if isEmbedded(filename) {
+		return data.Graphs.Open(path.Join(embeddedDir, strings.TrimPrefix(filename, embeddedPrefix)))
+	}
+	return os.Open(filename)
+}
+
+// graphFileExists answers true if the graph file, which can be embedded, exists.
+func graphFileExists(filename string) bool {
+	if isEmbedded(filename) {
+		_, err := fs.Stat(data.Graphs, path.Join(embeddedDir, strings.TrimPrefix(filename, embeddedPrefix)))
+		return err == nil
+	}
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// relativeGraphFile answers the filename relative to the folder of the
+// graph file base, which can be embedded.
+func relativeGraphFile(base, filename string) string {
+	if isEmbedded(base) {
+		return embeddedPrefix + path.Join(path.Dir(strings.TrimPrefix(base, embeddedPrefix)), filename)
+	}
+	if filepath.IsAbs(filename) {
+		return filename
+	}
+	return filepath.Join(filepath.Dir(base), filename)
+}
+
+// absGraphFile answers the absolute filename of the graph file, which can be embedded.
+func absGraphFile(filename string) string {
+	if isEmbedded(filename) {
+		return filename
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return filename
+	}
+	return abs
+}
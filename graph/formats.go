@@ -0,0 +1,467 @@
+package graph
+
+// Support graph files in YAML, JSON and TOML. Each format is decoded into a
+// generic tree, which is then translated into the equivalent XML document
+// and loaded normally, so every format shares the same semantics.
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// The graph file extensions, in order of precedence.
+var graphExts = []string{".xml", ".yaml", ".yml", ".json", ".toml"}
+
+// object is a decoded map that remembers the order of its keys and,
+// when the format supplies them, the line of the map and of each key.
+type object struct {
+	line   int
+	keys   []string
+	lines  []int
+	values map[string]interface{}
+}
+
+func newObject(line int) *object {
+	return &object{line: line, values: make(map[string]interface{})}
+}
+
+func (o *object) set(key string, value interface{}, line int) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+		o.lines = append(o.lines, line)
+	}
+	o.values[key] = value
+}
+
+// decodeTree is a function that decodes a file into a tree of
+// *object, []interface{} and scalar values.
+type decodeTree func(data []byte) (interface{}, error)
+
+// openGraph answers a reader of XML for the graph file, translating
+// other formats based on the file extension. Answer false if the lines
+// of the XML don't match the lines of the file.
+func openGraph(filename string, r io.Reader) (io.Reader, bool, error) {
+	var decode decodeTree
+	hasLines := true
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		decode = decodeYaml
+	case ".json":
+		decode = decodeJson
+	case ".toml":
+		decode = decodeToml
+		hasLines = false
+	default:
+		return r, true, nil
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, false, err
+	}
+	tree, err := decode(data)
+	if err != nil {
+		return nil, false, err
+	}
+	root, ok := tree.(*object)
+	if !ok {
+		return nil, false, errors.New("Graph file " + filename + " must contain a map")
+	}
+	xr, err := translateGraph(root)
+	return xr, hasLines, err
+}
+
+// -----------------------------------------------
+// DECODING
+
+func decodeYaml(data []byte) (interface{}, error) {
+	var doc yaml.Node
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+	if len(doc.Content) < 1 {
+		return newObject(0), nil
+	}
+	return yamlValue(doc.Content[0])
+}
+
+func yamlValue(n *yaml.Node) (interface{}, error) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		o := newObject(n.Line)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			v, err := yamlValue(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			o.set(n.Content[i].Value, v, n.Content[i].Line)
+		}
+		return o, nil
+	case yaml.SequenceNode:
+		var list []interface{}
+		for _, c := range n.Content {
+			v, err := yamlValue(c)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case yaml.ScalarNode:
+		if n.Tag == "!!null" {
+			return nil, nil
+		}
+		return n.Value, nil
+	case yaml.AliasNode:
+		return yamlValue(n.Alias)
+	}
+	return nil, fmt.Errorf("Unsupported yaml at line %v", n.Line)
+}
+
+func decodeJson(data []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	return jsonValue(d, data)
+}
+
+func jsonValue(d *json.Decoder, data []byte) (interface{}, error) {
+	t, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch v := t.(type) {
+	case json.Delim:
+		if v == '{' {
+			o := newObject(jsonLine(d, data))
+			for d.More() {
+				kt, err := d.Token()
+				if err != nil {
+					return nil, err
+				}
+				line := jsonLine(d, data)
+				value, err := jsonValue(d, data)
+				if err != nil {
+					return nil, err
+				}
+				o.set(kt.(string), value, line)
+			}
+			_, err = d.Token()
+			return o, err
+		} else if v == '[' {
+			var list []interface{}
+			for d.More() {
+				value, err := jsonValue(d, data)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, value)
+			}
+			_, err = d.Token()
+			return list, err
+		}
+		return nil, errors.New("Unexpected json delimiter " + v.String())
+	}
+	return t, nil
+}
+
+// jsonLine answers the line of the last token read by the decoder.
+func jsonLine(d *json.Decoder, data []byte) int {
+	return bytes.Count(data[:d.InputOffset()], []byte("\n")) + 1
+}
+
+func decodeToml(data []byte) (interface{}, error) {
+	var m map[string]interface{}
+	md, err := toml.Decode(string(data), &m)
+	if err != nil {
+		return nil, err
+	}
+	// TOML maps are unordered, so restore the order from the metadata.
+	order := make(map[string]int)
+	for i, k := range md.Keys() {
+		path := strings.Join(k, ".")
+		if _, ok := order[path]; !ok {
+			order[path] = i
+		}
+	}
+	return tomlValue(m, "", order), nil
+}
+
+func tomlValue(v interface{}, path string, order map[string]int) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		var keys []string
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.SliceStable(keys, func(i, j int) bool {
+			return order[path+keys[i]] < order[path+keys[j]]
+		})
+		// TOML doesn't supply lines.
+		o := newObject(0)
+		for _, k := range keys {
+			o.set(k, tomlValue(t[k], path+k+".", order), 0)
+		}
+		return o
+	case []map[string]interface{}:
+		var list []interface{}
+		for _, c := range t {
+			list = append(list, tomlValue(c, path, order))
+		}
+		return list
+	case []interface{}:
+		var list []interface{}
+		for _, c := range t {
+			list = append(list, tomlValue(c, path, order))
+		}
+		return list
+	}
+	return v
+}
+
+// -----------------------------------------------
+// TRANSLATING
+
+// translator converts a tree into an XML graph document. When the tree has
+// line numbers, the document is padded so each element starts on the same
+// line as its source, so problems can be reported at the right position.
+// The rules are:
+//   - "args" and "macros" are maps, where each key is an arg or macro. A scalar
+//     value is the arg or macro value, a map supplies attributes (i.e. "usage").
+//     This applies anywhere, i.e. the args of an include.
+//   - "nodes" is a list of maps, where "type" is the name of the node element.
+//   - Everywhere else, a scalar is an attribute, a map is a child element, and
+//     a list is a child element for each item, where a scalar item is the text.
+//   - The key "text" is the text of the element.
+//   - The key "children" is a list of single-key maps, each a child element.
+//     This can be used when a child element has the same name as an attribute.
+type translator struct {
+	enc  *xml.Encoder
+	line int
+}
+
+func translateGraph(root *object) (io.Reader, error) {
+	var buf bytes.Buffer
+	t := translator{xml.NewEncoder(&buf), 1}
+	start, text := startElement("graph", root, "")
+	err := t.encodeStart(start, text, root.line)
+	for i, k := range root.keys {
+		if err != nil {
+			return nil, err
+		}
+		v := root.values[k]
+		if _, ok := scalarString(v); ok {
+			continue
+		}
+		if k == "args" || k == "macros" {
+			err = t.translateSection(k, v, root.lines[i])
+		} else if k == "nodes" {
+			err = t.translateNodes(v, root.lines[i])
+		} else {
+			err = t.translateValue(k, v, root.lines[i])
+		}
+	}
+	if err == nil {
+		err = t.enc.EncodeToken(start.End())
+	}
+	if err == nil {
+		err = t.enc.Flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// translateObject encodes a map as an element at the line, ignoring the omit key.
+func (t *translator) translateObject(name string, o *object, omit string, line int) error {
+	start, text := startElement(name, o, omit)
+	err := t.encodeStart(start, text, line)
+	for i, k := range o.keys {
+		if err != nil {
+			return err
+		}
+		v := o.values[k]
+		if _, ok := scalarString(v); ok {
+			continue
+		}
+		if k == "children" {
+			err = t.translateChildren(v)
+		} else if _, ok := v.(*object); ok && (k == "args" || k == "macros") {
+			err = t.translateSection(k, v, o.lines[i])
+		} else {
+			err = t.translateValue(k, v, o.lines[i])
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return t.enc.EncodeToken(start.End())
+}
+
+// startElement answers the start of an element with the scalars of the
+// map as attributes, along with the element text.
+func startElement(name string, o *object, omit string) (xml.StartElement, string) {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	text := ""
+	for _, k := range o.keys {
+		if s, ok := scalarString(o.values[k]); ok {
+			if k == "text" {
+				text = s
+			} else if k != omit {
+				start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: k}, Value: s})
+			}
+		}
+	}
+	return start, text
+}
+
+// encodeStart encodes the start of an element on the given line, if possible.
+func (t *translator) encodeStart(start xml.StartElement, text string, line int) error {
+	if line > t.line {
+		err := t.enc.EncodeToken(xml.CharData(strings.Repeat("\n", line-t.line)))
+		if err != nil {
+			return err
+		}
+		t.line = line
+	}
+	err := t.enc.EncodeToken(start)
+	if err == nil && text != "" {
+		err = t.enc.EncodeToken(xml.CharData(text))
+	}
+	return err
+}
+
+func (t *translator) translateValue(name string, v interface{}, line int) error {
+	if s, ok := scalarString(v); ok {
+		return t.translateText(name, s, line)
+	}
+	switch tv := v.(type) {
+	case *object:
+		// The element starts at its key, not at the first key of its map.
+		if line <= 0 {
+			line = tv.line
+		}
+		return t.translateObject(name, tv, "", line)
+	case []interface{}:
+		for _, item := range tv {
+			// Each map in a list starts at its own line.
+			itemLine := line
+			if o, ok := item.(*object); ok {
+				itemLine = o.line
+			}
+			err := t.translateValue(name, item, itemLine)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("Unsupported value for %v", name)
+}
+
+func (t *translator) translateText(name, text string, line int) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	err := t.encodeStart(start, text, line)
+	if err != nil {
+		return err
+	}
+	return t.enc.EncodeToken(start.End())
+}
+
+func (t *translator) translateSection(name string, v interface{}, line int) error {
+	o, ok := v.(*object)
+	if !ok {
+		return errors.New("Graph " + name + " must be a map")
+	}
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	err := t.encodeStart(start, "", line)
+	if err != nil {
+		return err
+	}
+	for i, k := range o.keys {
+		err = t.translateValue(k, o.values[k], o.lines[i])
+		if err != nil {
+			return err
+		}
+	}
+	return t.enc.EncodeToken(start.End())
+}
+
+func (t *translator) translateNodes(v interface{}, line int) error {
+	list, ok := v.([]interface{})
+	if !ok {
+		return errors.New("Graph nodes must be a list")
+	}
+	start := xml.StartElement{Name: xml.Name{Local: "nodes"}}
+	err := t.encodeStart(start, "", line)
+	if err != nil {
+		return err
+	}
+	for _, item := range list {
+		o, ok := item.(*object)
+		if !ok {
+			return errors.New("Each graph node must be a map")
+		}
+		name, _ := scalarString(o.values["type"])
+		if name == "" {
+			return errors.New("Each graph node must have a type")
+		}
+		err = t.translateObject(name, o, "type", o.line)
+		if err != nil {
+			return err
+		}
+	}
+	return t.enc.EncodeToken(start.End())
+}
+
+func (t *translator) translateChildren(v interface{}) error {
+	list, ok := v.([]interface{})
+	if !ok {
+		return errors.New("Children must be a list")
+	}
+	for _, item := range list {
+		o, ok := item.(*object)
+		if !ok || len(o.keys) != 1 {
+			return errors.New("Each child must be a map with a single key")
+		}
+		err := t.translateValue(o.keys[0], o.values[o.keys[0]], o.lines[0])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scalarString answers the string form of a scalar value, and false if the
+// value is not a scalar.
+func scalarString(v interface{}) (string, bool) {
+	switch t := v.(type) {
+	case nil:
+		return "", true
+	case string:
+		return t, true
+	case bool:
+		return strconv.FormatBool(t), true
+	case int64:
+		return strconv.FormatInt(t, 10), true
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), true
+	case json.Number:
+		return t.String(), true
+	case fmt.Stringer:
+		return t.String(), true
+	}
+	return "", false
+}
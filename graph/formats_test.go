@@ -0,0 +1,211 @@
+package graph
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// summary answers everything the graph was built from, to compare
+// graphs loaded from different formats.
+func summary(b *builder) []string {
+	g := b.graph
+	list := []string{
+		g.Description,
+		fmt.Sprintf("args %+v", g.Args),
+		fmt.Sprintf("macros %+v", g.Macros),
+		fmt.Sprintf("env %+v", g.Env),
+	}
+	inputs := inputNames(b)
+	for _, gn := range g._nodes {
+		list = append(list, fmt.Sprintf("<%v> %v inputs %v %+v", gn.kind, gn.name, inputs[gn.name], gn.node))
+	}
+	return list
+}
+
+func TestFormats(t *testing.T) {
+	files := []struct {
+		name string
+		text string
+	}{
+		{name: "g.xml", text: `<graph description="Build and run">
+	<args>
+		<watch usage="Folder to watch" type="path" default="/src" />
+		<run>app</run>
+	</args>
+	<macros>
+		<bin>${watch}/${run}</bin>
+	</macros>
+	<env name="PORT" value="8080" />
+	<nodes>
+		<watch name="w">
+			<folder filter=".go">${watch}</folder>
+		</watch>
+		<exec name="build" cmd="go" args="build -o ${bin}" inputs="w" interrupt="true">
+			<arg>-v</arg>
+			<cmd method="stop" target="run" reply="true" />
+		</exec>
+		<host name="run" cmd="${bin}" inputs="build" />
+	</nodes>
+</graph>`},
+		{name: "g.yaml", text: `description: Build and run
+args:
+  watch:
+    usage: Folder to watch
+    type: path
+    default: /src
+  run: app
+macros:
+  bin: ${watch}/${run}
+env:
+  - name: PORT
+    value: 8080
+nodes:
+  - type: watch
+    name: w
+    folder:
+      - text: ${watch}
+        filter: .go
+  - type: exec
+    name: build
+    cmd: go
+    args: build -o ${bin}
+    inputs: w
+    interrupt: true
+    arg: [-v]
+    children:
+      - cmd: {method: stop, target: run, reply: true}
+  - type: host
+    name: run
+    cmd: ${bin}
+    inputs: build
+`},
+		{name: "g.json", text: `{
+	"description": "Build and run",
+	"args": {
+		"watch": {"usage": "Folder to watch", "type": "path", "default": "/src"},
+		"run": "app"
+	},
+	"macros": {"bin": "${watch}/${run}"},
+	"env": [{"name": "PORT", "value": 8080}],
+	"nodes": [
+		{"type": "watch", "name": "w", "folder": [{"text": "${watch}", "filter": ".go"}]},
+		{"type": "exec", "name": "build", "cmd": "go", "args": "build -o ${bin}", "inputs": "w", "interrupt": true,
+			"arg": ["-v"], "children": [{"cmd": {"method": "stop", "target": "run", "reply": true}}]},
+		{"type": "host", "name": "run", "cmd": "${bin}", "inputs": "build"}
+	]
+}`},
+		{name: "g.toml", text: `description = "Build and run"
+
+[args]
+watch = { usage = "Folder to watch", type = "path", default = "/src" }
+run = "app"
+
+[macros]
+bin = "${watch}/${run}"
+
+[[env]]
+name = "PORT"
+value = 8080
+
+[[nodes]]
+type = "watch"
+name = "w"
+folder = [{ text = "${watch}", filter = ".go" }]
+
+[[nodes]]
+type = "exec"
+name = "build"
+cmd = "go"
+args = "build -o ${bin}"
+inputs = "w"
+interrupt = true
+arg = ["-v"]
+[[nodes.children]]
+cmd = { method = "stop", target = "run", reply = true }
+
+[[nodes]]
+type = "host"
+name = "run"
+cmd = "${bin}"
+inputs = "build"
+`},
+	}
+	dir := t.TempDir()
+	var want []string
+	for _, f := range files {
+		b := load(writeGraph(t, dir, f.name, f.text), nil, true)
+		if len(b.problems) > 0 {
+			t.Errorf("%v: problems %v", f.name, problemList(b.problems))
+			continue
+		}
+		got := summary(b)
+		if want == nil {
+			want = got
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: graph =\n%v\nwant the same as the XML\n%v", f.name, got, want)
+		}
+	}
+}
+
+func TestFormatLines(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{name: "g.yaml", text: `args:
+  watch:
+    usage: Folder to watch
+nodes:
+  - type: exec
+    cmd: go
+    autorn: true
+  - type: host
+    cmd: ${nope}
+`, want: []string{
+			`2: warning: Arg "watch" is never used`,
+			`5: Unknown attribute "autorn" on <exec>`,
+			`8: Undefined variable ${nope}`,
+		}},
+		{name: "g.json", text: `{
+	"nodes": [
+		{"type": "exec", "cmd": "go"},
+		{"type": "bogus"},
+		{"type": "host",
+			"cmd": "${nope}"}
+	]
+}`, want: []string{
+			`4: Unknown node type <bogus>`,
+			`5: Undefined variable ${nope}`,
+		}},
+		// TOML doesn't supply lines.
+		{name: "g.toml", text: `[[nodes]]
+type = "host"
+cmd = "${nope}"
+`, want: []string{
+			`0: Undefined variable ${nope}`,
+		}},
+		{name: "g.yaml", text: "nodes: [", want: []string{
+			`0: yaml: line 1: did not find expected node content`,
+		}},
+		{name: "g.yaml", text: "- a\n- b\n", want: []string{
+			`0: Graph file g.yaml must contain a map`,
+		}},
+		{name: "g.json", text: `{"nodes": {"type": "exec"}}`, want: []string{
+			`0: Graph nodes must be a list`,
+		}},
+	}
+	dir := t.TempDir()
+	for _, c := range cases {
+		filename := writeGraph(t, dir, c.name, c.text)
+		got := problemList(load(filename, nil, true).problems)
+		for i := range c.want {
+			c.want[i] = strings.Replace(c.want[i], c.name, filename, -1)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: problems = %q, want %q", c.text, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package graph
+
+// Include the nodes of one graph file in another.
+
+import (
+	"path/filepath"
+
+	"github.com/hackborn/ghost/node"
+)
+
+// include is an element that includes the nodes of another graph file.
+// The included nodes are named "<include name>.<node name>".
+type include struct {
+	File string `xml:"file,attr"`
+	Name string `xml:"name,attr"`
+	// Values for the args of the included graph.
+	Args Args `xml:"args"`
+}
+
+// decodeInclude adds the included graph file as a new level.
+func (b *builder) decodeInclude(e *element, line int) {
+	var inc include
+	b.lint(e, schemaFor(inc), "inputs")
+	if !b.decodeElement(e, &inc) {
+		return
+	}
+	if inc.File == "" {
+		b.errorf(line, "<include> requires a file")
+		return
+	}
+	name := inc.Name
+	if name == "" {
+		name = formatName(filepath.Base(inc.File))
+	}
+
+	// Files are relative to the including file, otherwise they're found like any graph.
+	filename := relativeGraphFile(b.file, inc.File)
+	if !graphFileExists(filename) {
+		if found, ferr := Find(inc.File); ferr == nil {
+			filename = found
+		}
+	}
+	abs := absGraphFile(filename)
+	for _, f := range b.including {
+		if f == abs {
+			b.errorf(line, "Include \"%v\" includes itself", inc.File)
+			return
+		}
+	}
+
+	sub := &level{prefix: b.cur.prefix + name + ".", args: &Args{}, macros: &Macros{}, env: &[]node.EnvVar{}, parent: b.cur}
+	u := &unit{name: b.cur.prefix + name, inputs: decodeInputs(e.start()), pos: b.pos(line), level: b.cur, sub: sub, bindings: inc.Args}
+	b.all = append(b.all, u)
+	b.cur.units = append(b.cur.units, u)
+
+	b.loadLevel(filename, sub)
+
+	if len(sub.units) <= 0 {
+		b.errorf(line, "Include \"%v\" has no nodes", inc.File)
+	}
+}
@@ -0,0 +1,122 @@
+package graph
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hackborn/ghost/node"
+)
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeGraph(t, dir, "sub.xml", `<graph>
+	<args>
+		<port />
+		<mode>debug</mode>
+	</args>
+	<nodes>
+		<exec name="build" cmd="go" args="build ${mode}" />
+		<host name="run" cmd="app" args="-port=${port} ${tool}" />
+	</nodes>
+</graph>`)
+	writeGraph(t, dir, "lint.xml", `<graph>
+	<nodes>
+		<exec name="lint" cmd="lint" inputs="first" />
+	</nodes>
+</graph>`)
+	main := writeGraph(t, dir, "main.xml", `<graph>
+	<args>
+		<port>9000</port>
+	</args>
+	<macros>
+		<tool>vet</tool>
+	</macros>
+	<nodes>
+		<exec name="first" cmd="x" />
+		<include name="be" file="sub.xml" />
+		<include file="sub.xml" inputs="first">
+			<args>
+				<port>1</port>
+				<mode>release</mode>
+			</args>
+		</include>
+		<include file="lint.xml" />
+		<exec name="last" cmd="x" inputs="be, sub.run:on_failure" />
+	</nodes>
+</graph>`)
+	b := load(main, nil, true)
+	if len(b.problems) > 0 {
+		t.Fatalf("problems %v", problemList(b.problems))
+	}
+
+	wantInputs := map[string][]string{
+		"first":     {"graph"},
+		"be.build":  {"graph"},
+		"be.run":    {"be.build"},
+		"sub.build": {"first"},
+		"sub.run":   {"sub.build"},
+		"lint.lint": {"first"},
+		"last":      {"be.run", "sub.run:on_failure"},
+	}
+	if got := inputNames(b); !reflect.DeepEqual(got, wantInputs) {
+		t.Errorf("inputs = %v, want %v", got, wantInputs)
+	}
+
+	wantArgs := map[string]string{
+		"first":     "",
+		"be.build":  "build debug",
+		"be.run":    "-port=9000 vet",
+		"sub.build": "build release",
+		"sub.run":   "-port=1 vet",
+		"lint.lint": "",
+		"last":      "",
+	}
+	gotArgs := make(map[string]string)
+	for _, gn := range b.graph._nodes {
+		gotArgs[gn.name] = gn.node.(*node.Exec).Args
+	}
+	if !reflect.DeepEqual(gotArgs, wantArgs) {
+		t.Errorf("args = %v, want %v", gotArgs, wantArgs)
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		graph string
+		want  []string
+	}{
+		{name: "unknown arg", graph: `<graph><nodes>
+	<include name="be" file="sub.xml">
+		<args><nope>1</nope></args>
+	</include>
+</nodes></graph>`, want: []string{`2: Include "be" has no arg "nope"`}},
+		{name: "itself", graph: `<graph><nodes>
+	<exec cmd="x" />
+	<include file="g.xml" />
+</nodes></graph>`, want: []string{`3: Include "g.xml" includes itself`}},
+		{name: "no file", graph: `<graph><nodes>
+	<exec cmd="x" />
+	<include name="x" />
+</nodes></graph>`, want: []string{`3: <include> requires a file`}},
+		{name: "no nodes", graph: `<graph><nodes>
+	<exec cmd="x" />
+	<include file="empty.xml" />
+</nodes></graph>`, want: []string{`3: Include "empty.xml" has no nodes`}},
+		{name: "include as cmd target", graph: `<graph><nodes>
+	<exec cmd="x">
+		<cmd method="stop" target="be" />
+	</exec>
+	<include name="be" file="sub.xml" />
+</nodes></graph>`, want: []string{`2: Cmd target: "be" is an include, not a node`}},
+	}
+	dir := t.TempDir()
+	writeGraph(t, dir, "sub.xml", `<graph><nodes><exec name="build" cmd="go" /></nodes></graph>`)
+	writeGraph(t, dir, "empty.xml", `<graph><nodes></nodes></graph>`)
+	for _, c := range cases {
+		b := load(writeGraph(t, dir, "g.xml", c.graph), nil, true)
+		if got := problemList(b.problems); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: problems = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
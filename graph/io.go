@@ -8,137 +8,731 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
-	"github.com/kardianos/osext"
-
 	"github.com/hackborn/ghost/node"
 )
 
-// A message passed between nodes.
+// builder collects the nodes and connections found while decoding a graph
+// file and any files it includes, then wires them together.
 type builder struct {
 	graph *Graph
 	order []node.Node
+	// The unit for each node in order.
+	units []*unit
+	// Every unit, including includes.
+	all []*unit
+	// The contents of the graph file. Included files are levels below this.
+	root *level
+	// The level, file and include stack currently being decoded. Lines are
+	// 0 when the file format can't supply them.
+	cur       *level
+	file      string
+	hasLines  bool
+	including []string
+	// Every file that was loaded.
+	files    []string
+	problems Problems
+	// When false the arg values aren't checked, i.e. required args can be missing.
+	checkValues bool
+	// The Id for the next node.
+	nextId node.Id
+	// The index of the node currently filling in its cmd target IDs.
+	filling int
+}
+
+// position is the location of an element in a graph file.
+type position struct {
+	file string
+	line int
+}
+
+// level is the contents of a single graph file: the file being loaded,
+// or a file it includes.
+type level struct {
+	// The file the level was loaded from.
+	file string
+	// The prefix added to the names of everything in the level.
+	prefix string
+	units  []*unit
+	edges  []edge
+	// The args and macros visible to the level, which also has access
+	// to the args and macros of its parent.
+	args     *Args
+	macros   *Macros
+	argPos   []position
+	macroPos []position
+	// Environment variables for the nodes of the level and any it includes.
+	env    *[]node.EnvVar
+	envPos []position
+	parent *level
+	// The description of the file, from the description attribute or element.
+	description string
+	// The folder node log files go in, from the logdir attribute.
+	logDir    string
+	logDirPos position
+}
+
+// unit is a node or an include, as it appears in its level.
+type unit struct {
+	// The full name, including the prefix of the level.
+	name string
+	// The node, or nil for an include, and its element name.
+	node node.Node
+	kind string
+	// The names of the inputs, taken from the "inputs" attribute.
+	inputs []string
+	pos    position
+	level  *level
+	// The included level, and its arg bindings.
+	sub      *level
+	bindings Args
+}
+
+// edge is an explicit connection between two nodes.
+type edge struct {
+	From string `xml:"from,attr"`
+	To   string `xml:"to,attr"`
+	pos  position
+}
+
+func newBuilder() *builder {
+	b := &builder{graph: NewGraph(), nextId: 1}
+	b.root = &level{args: &b.graph.Args, macros: &b.graph.Macros, env: &b.graph.Env}
+	b.cur = b.root
+	return b
+}
+
+func (b *builder) add(n node.Node, kind string, inputs []string, line int) {
+	u := &unit{name: b.cur.prefix + n.GetName(), node: n, kind: kind, inputs: inputs, pos: b.pos(line), level: b.cur}
+	b.graph.add(n, kind, u.name)
+	b.order = append(b.order, n)
+	b.units = append(b.units, u)
+	b.all = append(b.all, u)
+	b.cur.units = append(b.cur.units, u)
+}
+
+func (b *builder) pos(line int) position {
+	if !b.hasLines {
+		line = 0
+	}
+	return position{b.file, line}
 }
 
 func (b *builder) build(la LoadArgs) {
-	// For now there's no branching or multiple connections, so whatever
-	// order was specified in the file is the order I'll use.
 	if b.graph == nil {
 		return
 	}
 
+	b.applyDefaults(b.root)
 	if la != nil {
-		la(&b.graph.Args)
-	}
-	b.graph.Macros.Expand(b.graph.Args)
-	b.graph.Expand(b.graph.Args)
-	b.graph.Expand(b.graph.Macros)
-
-	// Construct the inputs for each node. Right now it's very simple,
-	// a single channel connection between each node based on the order
-	// found in the graph file.
-	var prev node.Node = nil
-	for _, n := range b.order {
-		if n != nil {
-			if prev == nil {
-				// If this is the first, then the graph is the input.
-				b.graph.addInput(n, b.graph)
-			} else {
-				b.graph.addInput(n, prev)
+		err := la(&b.graph.Args)
+		if err != nil {
+			b.errorAt(position{b.root.file, 0}, "Command line: %v", err)
+		}
+	}
+	b.checkNames(b.root)
+	b.checkArgTypes(b.root)
+	refs := b.findRefs()
+	if !b.checkValues {
+		// Args can be missing, so only the values that don't use
+		// variables are known, and they're checked before expanding.
+		b.validateNodes()
+	}
+	b.expand(b.root)
+	b.checkUndefined()
+	b.unescapeLevel(b.root)
+	if b.checkValues {
+		b.validateNodes()
+	}
+	b.checkUnused(b.root, refs)
+
+	b.connect()
+}
+
+// expand() replaces the variables in the macros and nodes of the level,
+// then binds the args of each included level and expands it.
+func (b *builder) expand(l *level) {
+	if b.checkValues {
+		b.checkArgValues(l)
+	}
+	b.resolveMacros(l)
+	for i := 0; i < len(*l.env); i++ {
+		v := &(*l.env)[i]
+		v.Value = l.ChangeString(v.Value)
+	}
+	l.logDir = l.ChangeString(l.logDir)
+	// The nodes get copies of these, so they're unescaped now.
+	env := l.environment()
+	for i := range env {
+		env[i].Value = unescape(env[i].Value)
+	}
+	logDir := unescape(l.logFolder())
+	for _, u := range l.units {
+		if u.node != nil {
+			u.node.ApplyArgs(l)
+			if fn, ok := u.node.(node.FullNamer); ok && u.node.GetName() != "" {
+				fn.InheritFullName(u.name)
+			}
+			if e, ok := u.node.(node.EnvUser); ok && len(env) > 0 {
+				e.InheritEnv(env)
+			}
+			if lu, ok := u.node.(node.LogUser); ok && logDir != "" {
+				lu.InheritLogDir(logDir, b.logName(u))
 			}
-			prev = n
 		}
 	}
+	for _, u := range l.units {
+		if u.sub == nil {
+			continue
+		}
+		for _, binding := range u.bindings.Arg {
+			if !u.sub.args.has(binding.XMLName.Local) {
+				b.errorAt(u.pos, "Include \"%v\" has no arg \"%v\"", u.name, binding.XMLName.Local)
+			}
+		}
+		for i := 0; i < len(u.sub.args.Arg); i++ {
+			dst := &u.sub.args.Arg[i]
+			if v, ok := u.bindings.get(dst.XMLName.Local); ok {
+				dst.Value = l.ChangeString(v)
+			} else if v, ok := l.lookup(dst.XMLName.Local); ok {
+				dst.Value = v
+			}
+		}
+		b.expand(u.sub)
+	}
 }
 
-func (b *builder) GetId(name string) node.Id {
-	for _, n := range b.order {
-		if n.GetName() == name {
-			return n.GetId()
+// unescapeLevel() leaves the escaped variables in the values of the level
+// and its includes as ${name}, once they can't be taken for undefined ones.
+func (b *builder) unescapeLevel(l *level) {
+	for i := range l.macros.List {
+		m := &l.macros.List[i]
+		m.Value = unescape(m.Value)
+	}
+	for i := range *l.env {
+		v := &(*l.env)[i]
+		v.Value = unescape(v.Value)
+	}
+	l.logDir = unescape(l.logDir)
+	for _, u := range l.units {
+		if u.node != nil {
+			u.node.ApplyArgs(unescaper{})
+		} else {
+			b.unescapeLevel(u.sub)
+		}
+	}
+}
+
+// environment() answers the environment variables of the level's
+// parents, then the level.
+func (l *level) environment() []node.EnvVar {
+	var env []node.EnvVar
+	if l.parent != nil {
+		env = l.parent.environment()
+	}
+	return append(env, *l.env...)
+}
+
+// logName() answers the default log file of the node: its name, or if
+// another node has the same name, its name and id.
+func (b *builder) logName(u *unit) string {
+	for _, gn := range b.graph._nodes {
+		if gn.name == u.name && gn.node != u.node {
+			return fmt.Sprintf("%v-%v.log", u.name, u.node.GetId())
 		}
 	}
-	return 0
+	return u.name + ".log"
+}
+
+// logFolder() answers the log folder of the level, or of its
+// nearest parent that has one.
+func (l *level) logFolder() string {
+	for ; l != nil; l = l.parent {
+		if l.logDir != "" {
+			return l.logDir
+		}
+	}
+	return ""
+}
+
+// lookup() answers the value of the arg or macro visible to the level.
+func (l *level) lookup(name string) (string, bool) {
+	if v, ok := l.args.get(name); ok {
+		return v, true
+	}
+	for _, m := range l.macros.List {
+		if m.XMLName.Local == name {
+			return m.Value, true
+		}
+	}
+	if l.parent != nil {
+		return l.parent.lookup(name)
+	}
+	return "", false
+}
+
+// sources are the inputs to a node: other nodes, and possibly the graph.
+type sources struct {
+	graph bool
+	ports []port
+}
+
+// port is a node feeding another, through its default output or a named one.
+type port struct {
+	node   node.Node
+	output string
+}
+
+func (s *sources) add(o sources) {
+	s.graph = s.graph || o.graph
+	for _, p := range o.ports {
+		s.ports = appendPort(s.ports, p)
+	}
+}
+
+func (s *sources) empty() bool {
+	return !s.graph && len(s.ports) <= 0
+}
+
+// connect() constructs the inputs for each node. In any level (i.e. graph
+// file) that names no inputs or edges, the nodes are chained in file order,
+// otherwise the named connections form a directed acyclic graph. The first
+// node of a chain, and any node without inputs, is fed by the graph, or by
+// the inputs to the include.
+func (b *builder) connect() {
+	inputs := make([]sources, len(b.order))
+	valid := b.connectLevel(b.root, sources{graph: true}, inputs)
+
+	nodeInputs := make([][]node.Node, len(b.order))
+	for i := range inputs {
+		for _, p := range inputs[i].ports {
+			nodeInputs[i] = appendNode(nodeInputs[i], p.node)
+		}
+	}
+	if cycle := b.findCycle(nodeInputs); len(cycle) > 0 {
+		var names []string
+		for _, n := range cycle {
+			names = append(names, b.units[b.indexOf(n)].name)
+		}
+		b.errorAt(b.units[b.indexOf(cycle[0])].pos, "Graph has a cycle: %v", strings.Join(names, " -> "))
+		valid = false
+	}
+	if !valid {
+		return
+	}
+
+	for i, n := range b.order {
+		if inputs[i].graph {
+			b.graph.addInput(n, b.graph)
+		}
+		for _, p := range inputs[i].ports {
+			if p.output == "" {
+				b.graph.addInput(n, p.node)
+			} else if o, ok := p.node.(node.Outputter); ok {
+				// Checked by source().
+				out, _ := o.Output(p.output)
+				b.graph.addInput(n, out)
+			}
+		}
+	}
+}
+
+// connectLevel() adds the inputs to every node in the level, where entry
+// feeds the start of the level. Answer false if there were problems.
+func (b *builder) connectLevel(l *level, entry sources, inputs []sources) bool {
+	valid := true
+	unitInputs := make([]sources, len(l.units))
+	if !l.hasConnections() {
+		prev := entry
+		for i, u := range l.units {
+			unitInputs[i] = prev
+			prev = b.exits(u)
+		}
+	} else {
+		declared := make([]bool, len(l.units))
+		for i, u := range l.units {
+			for _, name := range u.inputs {
+				declared[i] = true
+				src, err := b.source(l, name)
+				if err != nil {
+					b.errorAt(u.pos, "Node \"%v\" input: %v", u.name, err)
+					valid = false
+					continue
+				}
+				unitInputs[i].add(src)
+			}
+		}
+		for _, e := range l.edges {
+			src, srcErr := b.source(l, e.From)
+			if srcErr != nil {
+				b.errorAt(e.pos, "Edge from: %v", srcErr)
+				valid = false
+			}
+			dst, err := b.resolve(l, e.To)
+			if err != nil {
+				b.errorAt(e.pos, "Edge to: %v", err)
+				valid = false
+			} else if dst.level != l {
+				b.errorAt(e.pos, "Edge to: \"%v\" is not in the same file as the edge", e.To)
+				valid = false
+			}
+			if srcErr == nil && dst != nil && dst.level == l {
+				i := l.indexOf(dst)
+				declared[i] = true
+				unitInputs[i].add(src)
+			}
+		}
+		for i := range l.units {
+			if !declared[i] {
+				unitInputs[i] = entry
+			}
+		}
+	}
+
+	for i, u := range l.units {
+		if u.node != nil {
+			inputs[b.indexOf(u.node)].add(unitInputs[i])
+		} else if !b.connectLevel(u.sub, unitInputs[i], inputs) {
+			valid = false
+		}
+	}
+	return valid
+}
+
+// exits() answers the nodes that send the output of the unit. For an
+// include this is every node in the included level that doesn't feed
+// another node in that level.
+func (b *builder) exits(u *unit) sources {
+	if u.node != nil {
+		return sources{ports: []port{{u.node, ""}}}
+	}
+	l := u.sub
+	if !l.hasConnections() {
+		if len(l.units) <= 0 {
+			return sources{}
+		}
+		return b.exits(l.units[len(l.units)-1])
+	}
+	consumed := make(map[*unit]bool)
+	for _, c := range l.units {
+		for _, name := range c.inputs {
+			name, _ = splitOutput(name)
+			if src, err := b.resolve(l, name); err == nil {
+				consumed[src] = true
+			}
+		}
+	}
+	for _, e := range l.edges {
+		name, _ := splitOutput(e.From)
+		if src, err := b.resolve(l, name); err == nil {
+			consumed[src] = true
+		}
+	}
+	var s sources
+	for _, c := range l.units {
+		if !consumed[c] {
+			s.add(b.exits(c))
+		}
+	}
+	return s
+}
+
+func (l *level) hasConnections() bool {
+	if len(l.edges) > 0 {
+		return true
+	}
+	for _, u := range l.units {
+		if len(u.inputs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *level) indexOf(u *unit) int {
+	for i, v := range l.units {
+		if v == u {
+			return i
+		}
+	}
+	return -1
+}
+
+// source() answers the outputs an input refers to: a node name, optionally
+// followed by ":" and the name of one of the node's outputs, i.e.
+// "build:on_failure". For an include, the output applies to each of its exits.
+func (b *builder) source(l *level, input string) (sources, error) {
+	name, output := splitOutput(input)
+	u, err := b.resolve(l, name)
+	if err != nil {
+		return sources{}, err
+	}
+	s := b.exits(u)
+	if output == "" {
+		return s, nil
+	}
+	for i := range s.ports {
+		o, ok := s.ports[i].node.(node.Outputter)
+		if !ok {
+			return sources{}, errors.New("Node \"" + name + "\" has no output \"" + output + "\"")
+		}
+		if _, err := o.Output(output); err != nil {
+			return sources{}, errors.New("Node \"" + name + "\" has " + err.Error())
+		}
+		s.ports[i].output = output
+	}
+	return s, nil
+}
+
+// splitOutput() splits an input into the node name and the output name, if any.
+func splitOutput(input string) (string, string) {
+	if i := strings.LastIndex(input, ":"); i >= 0 {
+		return input[:i], input[i+1:]
+	}
+	return input, ""
+}
+
+// resolve() answers the single unit with the given name, as seen from
+// the level. Names in the level take precedence over the names in its parents.
+func (b *builder) resolve(l *level, name string) (*unit, error) {
+	for ; l != nil; l = l.parent {
+		var found *unit
+		full := l.prefix + name
+		for _, u := range b.all {
+			if u.name == full {
+				if found != nil {
+					return nil, errors.New("Ambiguous node name \"" + full + "\", set a unique name attribute")
+				}
+				found = u
+			}
+		}
+		if found != nil {
+			return found, nil
+		}
+	}
+	return nil, errors.New("No node named \"" + name + "\"")
+}
+
+func (b *builder) indexOf(n node.Node) int {
+	for i, v := range b.order {
+		if v == n {
+			return i
+		}
+	}
+	return -1
+}
+
+// findCycle() answers the nodes that form a cycle, or nothing if the
+// inputs are acyclic.
+func (b *builder) findCycle(inputs [][]node.Node) []node.Node {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(b.order))
+	var path []node.Node
+	var visit func(i int) []node.Node
+	visit = func(i int) []node.Node {
+		state[i] = visiting
+		path = append(path, b.order[i])
+		for _, src := range inputs[i] {
+			j := b.indexOf(src)
+			if state[j] == visiting {
+				// Report the cycle in data flow order, starting and ending at src.
+				var cycle []node.Node
+				for k := len(path) - 1; k >= 0; k-- {
+					cycle = append(cycle, path[k])
+					if path[k] == src {
+						break
+					}
+				}
+				return append([]node.Node{src}, cycle...)
+			} else if state[j] == unvisited {
+				if cycle := visit(j); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		return nil
+	}
+	for i := range b.order {
+		if state[i] == unvisited {
+			if cycle := visit(i); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+func appendPort(list []port, p port) []port {
+	for _, v := range list {
+		if v == p {
+			return list
+		}
+	}
+	return append(list, p)
+}
+
+func appendNode(list []node.Node, n node.Node) []node.Node {
+	for _, v := range list {
+		if v == n {
+			return list
+		}
+	}
+	return append(list, n)
+}
+
+// GetId answers the Id of the node with the given name, reporting
+// a problem if there isn't exactly one.
+func (b *builder) GetId(name string) node.Id {
+	u := b.units[b.filling]
+	target, err := b.resolve(u.level, name)
+	if err != nil {
+		b.errorAt(u.pos, "Cmd target: %v", err)
+		return 0
+	}
+	if target.node == nil {
+		b.errorAt(u.pos, "Cmd target: \"%v\" is an include, not a node", name)
+		return 0
+	}
+	return target.node.GetId()
 }
 
 // Find the graph with the given name and load it.
 func Load(n string, la LoadArgs) (*Graph, error) {
+	filename, err := Find(n)
+	if err != nil {
+		return nil, err
+	}
+	return LoadFile(filename, la)
+}
+
+// Find answers the filename of the graph with the given name, which is
+// either a path to a file, or the name of a graph in the search path or
+// compiled into the binary.
+func Find(n string) (string, error) {
 	// Directly load if this is a path to an existing file.
 	if _, err := os.Stat(n); err == nil {
-		return LoadFile(n, la)
+		return n, nil
 	}
 
-	// For now, look in our local data folder for a valid
-	// file matching the name.
-	p, err := osext.ExecutableFolder()
-	if err != nil {
-		return nil, err
-	}
-	p = path.Join(p, "data", "graphs")
 	// Search every location with graphs for the requested.
-	return loadFromPath(strings.ToLower(n), p, la)
+	dirs := SearchPath()
+	for _, p := range dirs {
+		if f, err := findInPath(strings.ToLower(n), p); err == nil {
+			return f, nil
+		}
+	}
+	// Finally, the defaults compiled into the binary.
+	if f, err := findEmbedded(strings.ToLower(n)); err == nil {
+		return f, nil
+	}
+	dirs = append(dirs, builtinLocation)
+	return "", errors.New("No graph named \"" + n + "\", looked in:\n\t" + strings.Join(dirs, "\n\t"))
 }
 
-// Construct a graph by loading from a filename.
+// Construct a graph by loading from a filename. If there are any
+// errors the graph is not constructed, and the error lists every problem.
 func LoadFile(filename string, la LoadArgs) (*Graph, error) {
-	xmlFile, err := os.Open(filename)
+	g, _, err := loadFile(filename, la)
 	if err != nil {
 		return nil, err
 	}
-	defer xmlFile.Close()
-	decoder := xml.NewDecoder(xmlFile)
-
-	var builder builder
-	builder.graph = NewGraph()
-
-	for {
-		token, err := decoder.Token()
-		if token == nil {
-			if err == nil {
-				continue
-			}
-			if err == io.EOF {
-				break
-			}
-			return nil, err
-		}
+	g.printWarnings()
+	return g, nil
+}
 
-		switch ele := token.(type) {
-		case xml.StartElement:
-			if ele.Name.Local == "args" {
-				decoder.DecodeElement(&builder.graph.Args, &ele)
-			} else if ele.Name.Local == "macros" {
-				decoder.DecodeElement(&builder.graph.Macros, &ele)
-			} else if ele.Name.Local == "nodes" {
-				decodeNodes(token, decoder, &builder)
-			}
+// loadFile is LoadFile, but also answers every file that was read,
+// even if there were errors. The warnings aren't printed, since
+// they're noise if the graph can't be used.
+func loadFile(filename string, la LoadArgs) (*Graph, []string, error) {
+	b := load(filename, la, true)
+	if errs := b.problems.Errors(); len(errs) > 0 {
+		return nil, b.files, errs
+	}
+	for _, p := range b.problems {
+		if p.Warning {
+			b.graph.warnings = append(b.graph.warnings, p)
 		}
 	}
+	//    fmt.Println("DONZO!", b.graph)
+	return b.graph, b.files, nil
+}
+
+// load reads and builds the graph file, answering the builder with the
+// graph and every problem that was found.
+func load(filename string, la LoadArgs, checkValues bool) *builder {
+	b := newBuilder()
+	b.checkValues = checkValues
+	b.loadLevel(filename, b.root)
 
 	// Fill in the IDs for all cmds. Ideally this would be handled
 	// completely inside the load -- with go 1.8 I think I can move to
 	// an abstract xml loading representation, and then just walk the
 	// tree. For now, I go through some functions on my domain objects.
-	for _, n := range builder.order {
-		n.FillIds(&builder)
+	for i, n := range b.order {
+		b.filling = i
+		n.FillIds(b)
 	}
 
-	builder.build(la)
-	//    fmt.Println("DONZO!", builder.graph)
-	return builder.graph, nil
+	b.build(la)
+	b.graph.Description = b.root.description
+	sort.SliceStable(b.problems, func(i, j int) bool {
+		if b.problems[i].File != b.problems[j].File {
+			return b.problems[i].File == filename
+		}
+		return b.problems[i].Line < b.problems[j].Line
+	})
+	return b
 }
 
-// Iterate the files in the path, loading any matching graph.
-func loadFromPath(n string, p string, la LoadArgs) (*Graph, error) {
-	p = path.Join(p, "*.xml")
-	files, _ := filepath.Glob(p)
-	for _, f := range files {
-		b := formatName(filepath.Base(f))
-		if n == b {
-			return LoadFile(f, la)
+// loadLevel decodes the file into the level.
+func (b *builder) loadLevel(filename string, l *level) {
+	prevLevel, prevFile, prevHasLines := b.cur, b.file, b.hasLines
+	defer func() {
+		b.cur, b.file, b.hasLines = prevLevel, prevFile, prevHasLines
+	}()
+	b.cur, b.file, b.hasLines = l, filename, true
+	l.file = filename
+	b.files = append(b.files, filename)
+	b.including = append(b.including, absGraphFile(filename))
+	defer func() {
+		b.including = b.including[:len(b.including)-1]
+	}()
+
+	file, err := openGraphFile(filename)
+	if err != nil {
+		b.errorf(0, "%v", err)
+		return
+	}
+	defer file.Close()
+	r, hasLines, err := openGraph(filename, file)
+	if err != nil {
+		b.errorf(0, "%v", err)
+		return
+	}
+	b.hasLines = hasLines
+	b.decode(xml.NewDecoder(r))
+}
+
+// Iterate the files in the path, answering any matching graph.
+func findInPath(n string, p string) (string, error) {
+	for _, ext := range graphExts {
+		files, _ := filepath.Glob(path.Join(p, "*"+ext))
+		for _, f := range files {
+			b := formatName(filepath.Base(f))
+			if n == b {
+				return f, nil
+			}
 		}
 	}
-	return nil, errors.New("No match")
+	return "", errors.New("No match")
 }
 
 // Given a fileaname base, format it so that I can compare against my input.
@@ -148,55 +742,158 @@ func formatName(n string) string {
 	return strings.ToLower(n)
 }
 
-func decodeNodes(token xml.Token, decoder *xml.Decoder, builder *builder) {
-	var id node.Id = 1
+// decode reads the graph sections from the XML.
+func (b *builder) decode(decoder *xml.Decoder) {
+	depth := 0
 	for {
 		token, err := decoder.Token()
-		if token == nil {
-			if err == nil {
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			b.decodeError(err)
+			return
+		}
+		line, _ := decoder.InputPos()
+
+		switch ele := token.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				if ele.Name.Local != "graph" {
+					b.errorf(line, "Unknown root element <%v>, must be <graph>", ele.Name.Local)
+				}
+				for _, a := range ele.Attr {
+					if a.Name.Local == "description" {
+						b.cur.description = strings.TrimSpace(a.Value)
+					} else if a.Name.Local == "logdir" {
+						b.cur.logDir, b.cur.logDirPos = a.Value, b.pos(line)
+					} else {
+						b.errorf(line, "Unknown attribute \"%v\" on <graph>", a.Name.Local)
+					}
+				}
+				depth++
 				continue
 			}
-			if err == io.EOF {
-				break
+			switch ele.Name.Local {
+			case "args":
+				e := b.readElement(decoder, ele, line)
+				if e == nil {
+					return
+				}
+				b.lint(e, schemaFor(b.cur.args))
+				b.decodeElement(e, b.cur.args)
+				for _, line := range e.childLines() {
+					b.cur.argPos = append(b.cur.argPos, b.pos(line))
+				}
+			case "macros":
+				e := b.readElement(decoder, ele, line)
+				if e == nil {
+					return
+				}
+				b.lint(e, schemaFor(b.cur.macros))
+				b.decodeElement(e, b.cur.macros)
+				for _, line := range e.childLines() {
+					b.cur.macroPos = append(b.cur.macroPos, b.pos(line))
+				}
+			case "env":
+				e := b.readElement(decoder, ele, line)
+				if e == nil {
+					return
+				}
+				var v node.EnvVar
+				b.lint(e, schemaFor(v))
+				if !b.decodeElement(e, &v) {
+					continue
+				}
+				if strings.TrimSpace(v.Name) == "" {
+					b.errorf(line, "<env> requires a name")
+					continue
+				}
+				*b.cur.env = append(*b.cur.env, v)
+				b.cur.envPos = append(b.cur.envPos, b.pos(line))
+			case "description":
+				e := b.readElement(decoder, ele, line)
+				if e == nil {
+					return
+				}
+				var desc description
+				b.lint(e, schemaFor(desc))
+				if b.decodeElement(e, &desc) {
+					b.cur.description = strings.TrimSpace(desc.Text)
+				}
+			case "nodes":
+				if !b.decodeNodes(decoder) {
+					return
+				}
+			default:
+				b.errorf(line, "Unknown element <%v>", ele.Name.Local)
+				decoder.Skip()
 			}
-			return
+		case xml.EndElement:
+			depth--
 		}
+	}
+}
+
+// decodeNodes reads each node in the nodes section. Answer false
+// if the XML can't be read any further.
+func (b *builder) decodeNodes(decoder *xml.Decoder) bool {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			b.decodeError(err)
+			return false
+		}
+		line, _ := decoder.InputPos()
 
-		var n node.Node
 		switch ele := token.(type) {
 		case xml.StartElement:
-			if ele.Name.Local == "watch" {
-				var v node.Watch
-				v.Id = id
-				v.Name = ele.Name.Local
-				decoder.DecodeElement(&v, &ele)
-				n = &v
-			} else if ele.Name.Local == "exec" || ele.Name.Local == "host" {
-				var v node.Exec
-				v.Id = id
-				v.Name = ele.Name.Local
-				if ele.Name.Local == "host" {
-					v.Interrupt = true
-					v.Autorun = true
-					v.Rerun = true
-				}
-				decoder.DecodeElement(&v, &ele)
-				//				fmt.Println("exec decode", v)
-				if (&v).IsValid() {
-					n = &v
+			e := b.readElement(decoder, ele, line)
+			if e == nil {
+				return false
+			}
+			name := ele.Name.Local
+			if name == "edge" {
+				ed := edge{pos: b.pos(line)}
+				b.lint(e, schemaFor(ed))
+				b.decodeElement(e, &ed)
+				b.cur.edges = append(b.cur.edges, ed)
+			} else if name == "include" {
+				b.decodeInclude(e, line)
+			} else if factory := findNodeType(name); factory != nil {
+				n := factory(b.nextId, name)
+				if n == nil {
+					b.errorf(line, "Node type <%v> made no node", name)
+					continue
+				}
+				b.lint(e, schemaFor(n), "inputs")
+				if !b.decodeElement(e, n) {
+					continue
 				}
+				b.nextId++
+				b.add(n, name, decodeInputs(ele), line)
+			} else {
+				b.errorf(line, "Unknown node type <%v>", name)
 			}
 		case xml.EndElement:
-			if ele.Name.Local == "nodes" {
-				return
-			}
+			return true
 		}
-		if n != nil {
-			id++
-			builder.graph.add(n)
-			builder.order = append(builder.order, n)
+	}
+}
+
+// decodeInputs() answers the node names in the "inputs" attribute.
+func decodeInputs(ele xml.StartElement) []string {
+	var inputs []string
+	for _, a := range ele.Attr {
+		if a.Name.Local == "inputs" {
+			for _, v := range strings.Split(a.Value, ",") {
+				v = strings.TrimSpace(v)
+				if len(v) > 0 {
+					inputs = append(inputs, v)
+				}
+			}
 		}
 	}
+	return inputs
 }
 
 // UGH. This exists solely because I constantly have to enable and disable
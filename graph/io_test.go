@@ -0,0 +1,147 @@
+package graph
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hackborn/ghost/node"
+)
+
+// writeGraph writes the graph file to the folder, answering its path.
+func writeGraph(t *testing.T, dir, name, text string) string {
+	t.Helper()
+	filename := filepath.Join(dir, name)
+	if err := os.WriteFile(filename, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// problemList answers each problem as "line: msg", or "line: warning: msg".
+func problemList(ps Problems) []string {
+	var list []string
+	for _, p := range ps {
+		if p.Warning {
+			list = append(list, fmt.Sprintf("%v: warning: %v", p.Line, p.Msg))
+		} else {
+			list = append(list, fmt.Sprintf("%v: %v", p.Line, p.Msg))
+		}
+	}
+	return list
+}
+
+// inputNames answers the inputs of each node, by node name: "graph",
+// the name of a node, or the name of a node and one of its outputs.
+func inputNames(b *builder) map[string][]string {
+	names := make(map[string][]string)
+	for _, gn := range b.graph._nodes {
+		var list []string
+		for _, in := range gn.inputs {
+			switch src := in.(type) {
+			case *Graph:
+				list = append(list, "graph")
+			case *node.NamedOutput:
+				list = append(list, b.units[b.indexOf(src.Node)].name+":"+src.Name)
+			case node.Node:
+				list = append(list, b.units[b.indexOf(src)].name)
+			}
+		}
+		names[gn.name] = list
+	}
+	return names
+}
+
+func TestConnect(t *testing.T) {
+	cases := []struct {
+		name  string
+		graph string
+		want  map[string][]string
+	}{
+		{name: "chain", graph: `<graph><nodes>
+	<exec name="a" cmd="x" />
+	<exec name="b" cmd="x" />
+	<exec name="c" cmd="x" />
+</nodes></graph>`, want: map[string][]string{"a": {"graph"}, "b": {"a"}, "c": {"b"}}},
+		{name: "fan out and in", graph: `<graph><nodes>
+	<exec name="a" cmd="x" />
+	<exec name="b" cmd="x" inputs="a" />
+	<exec name="c" cmd="x" inputs="a" />
+	<exec name="d" cmd="x" inputs="b, c" />
+</nodes></graph>`, want: map[string][]string{"a": {"graph"}, "b": {"a"}, "c": {"a"}, "d": {"b", "c"}}},
+		{name: "any order", graph: `<graph><nodes>
+	<exec name="b" cmd="x" inputs="a" />
+	<exec name="a" cmd="x" />
+</nodes></graph>`, want: map[string][]string{"a": {"graph"}, "b": {"a"}}},
+		{name: "edges", graph: `<graph><nodes>
+	<exec name="a" cmd="x" />
+	<exec name="b" cmd="x" />
+	<exec name="c" cmd="x" />
+	<edge from="a" to="c" />
+	<edge from="b" to="c" />
+</nodes></graph>`, want: map[string][]string{"a": {"graph"}, "b": {"graph"}, "c": {"a", "b"}}},
+		{name: "outputs", graph: `<graph><nodes>
+	<exec name="a" cmd="x" />
+	<exec name="b" cmd="x" inputs="a:on_failure" />
+	<exec name="c" cmd="x" />
+	<edge from="a:on_complete" to="c" />
+</nodes></graph>`, want: map[string][]string{"a": {"graph"}, "b": {"a:on_failure"}, "c": {"a:on_complete"}}},
+	}
+	dir := t.TempDir()
+	for _, c := range cases {
+		b := load(writeGraph(t, dir, "g.xml", c.graph), nil, true)
+		if len(b.problems) > 0 {
+			t.Errorf("%v: problems %v", c.name, problemList(b.problems))
+			continue
+		}
+		if got := inputNames(b); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: inputs = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestConnectErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		graph string
+		want  []string
+	}{
+		{name: "dangling input", graph: `<graph><nodes>
+	<exec name="a" cmd="x" />
+	<exec name="b" cmd="x" inputs="a, zz" />
+</nodes></graph>`, want: []string{`3: Node "b" input: No node named "zz"`}},
+		{name: "dangling edge", graph: `<graph><nodes>
+	<exec name="a" cmd="x" />
+	<edge from="zz" to="a" />
+	<edge from="a" to="yy" />
+</nodes></graph>`, want: []string{`3: Edge from: No node named "zz"`, `4: Edge to: No node named "yy"`}},
+		{name: "unknown output", graph: `<graph><nodes>
+	<exec name="a" cmd="x" />
+	<exec name="b" cmd="x" inputs="a:on_maybe" />
+</nodes></graph>`, want: []string{`3: Node "b" input: Node "a" has unknown output "on_maybe" (must be on_success, on_failure, on_complete or on_ready)`}},
+		{name: "ambiguous", graph: `<graph><nodes>
+	<exec name="a" cmd="x" />
+	<exec name="a" cmd="y" />
+	<exec name="b" cmd="x" inputs="a" />
+</nodes></graph>`, want: []string{`4: Node "b" input: Ambiguous node name "a", set a unique name attribute`}},
+		{name: "cycle", graph: `<graph><nodes>
+	<exec name="a" cmd="x" inputs="c" />
+	<exec name="b" cmd="x" inputs="a" />
+	<exec name="c" cmd="x" inputs="b" />
+</nodes></graph>`, want: []string{`2: Graph has a cycle: a -> b -> c -> a`}},
+		{name: "self", graph: `<graph><nodes>
+	<exec name="a" cmd="x" />
+	<exec name="b" cmd="x" />
+	<edge from="b" to="b" />
+</nodes></graph>`, want: []string{`3: Graph has a cycle: b -> b`}},
+	}
+	dir := t.TempDir()
+	for _, c := range cases {
+		b := load(writeGraph(t, dir, "g.xml", c.graph), nil, true)
+		if got := problemList(b.problems); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: problems = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
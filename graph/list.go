@@ -0,0 +1,85 @@
+package graph
+
+// Describe the available graphs.
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// description is the element that describes a graph file.
+type description struct {
+	Text string `xml:",chardata"`
+}
+
+// Info describes a graph file.
+type Info struct {
+	// The name used to run the graph.
+	Name string
+	// The file the graph is loaded from.
+	File string
+	// The folder the graph was found in, or "(built-in graphs)".
+	Location    string
+	Description string
+	// The args that can be supplied on the command line.
+	Args []Arg
+	// True if a graph with the same name is found first, so this one can
+	// only be run by its file name.
+	Hidden bool
+}
+
+// builtinLocation is the location of the graphs compiled into the binary.
+const builtinLocation = "(built-in graphs)"
+
+// List answers every graph in the search path, followed by the graphs
+// compiled into the binary, in the order they're searched.
+func List() []Info {
+	var files []string
+	for _, p := range SearchPath() {
+		found := make(map[string]bool)
+		for _, ext := range graphExts {
+			matches, _ := filepath.Glob(path.Join(p, "*"+ext))
+			sort.Strings(matches)
+			for _, f := range matches {
+				// The first extension takes precedence, same as findInPath().
+				if n := formatName(filepath.Base(f)); !found[n] {
+					found[n] = true
+					files = append(files, f)
+				}
+			}
+		}
+	}
+	for _, n := range EmbeddedNames() {
+		if f, err := findEmbedded(n); err == nil {
+			files = append(files, f)
+		}
+	}
+
+	var infos []Info
+	seen := make(map[string]bool)
+	for _, f := range files {
+		info := ReadInfo(f)
+		info.Hidden = seen[info.Name]
+		seen[info.Name] = true
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// ReadInfo answers the description and args of the graph file. The file
+// doesn't need to be valid; whatever can be read is answered.
+func ReadInfo(filename string) Info {
+	info := Info{File: filename}
+	if isEmbedded(filename) {
+		info.Name = formatName(filename[len(embeddedPrefix):])
+		info.Location = builtinLocation
+	} else {
+		info.Name = formatName(filepath.Base(filename))
+		info.Location = filepath.Dir(filename)
+	}
+	b := load(filename, nil, false)
+	info.Description = b.root.description
+	info.Args = b.root.args.Arg
+	return info
+}
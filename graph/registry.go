@@ -0,0 +1,91 @@
+package graph
+
+// Map graph file element names to the nodes they construct.
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/hackborn/ghost/node"
+)
+
+// NodeFactory answers a new node for an element in a graph file. The node
+// is decoded from the element after it's constructed, so it must be a pointer
+// to an xml-decodable struct, and any values the factory sets act as presets
+// that the element's attributes can override. If the node is a
+// node.Validator, or has a Validate() error or IsValid() bool function,
+// the graph reports a problem when the node isn't valid.
+type NodeFactory func(id node.Id, name string) node.Node
+
+var (
+	registrymu sync.Mutex
+	registry   = map[string]NodeFactory{}
+)
+
+func init() {
+	RegisterNodeType("watch", func(id node.Id, name string) node.Node {
+		return &node.Watch{Id: id, Name: name}
+	})
+	RegisterNodeType("exec", func(id node.Id, name string) node.Node {
+		return &node.Exec{Id: id, Name: name}
+	})
+	RegisterNodeType("host", func(id node.Id, name string) node.Node {
+		return &node.Exec{Id: id, Name: name, Interrupt: true, Autorun: true, Rerun: true}
+	})
+}
+
+// RegisterNodeType makes a node type available to graph files, where it's
+// created by any element in <nodes> with the given name. Register types
+// before loading graphs, typically from an init() function.
+func RegisterNodeType(name string, factory NodeFactory) error {
+	if name == "" {
+		return errors.New("Node type needs a name")
+	}
+	if name == "edge" || name == "include" {
+		return errors.New("Node type \"" + name + "\" is reserved")
+	}
+	if factory == nil {
+		return errors.New("Node type \"" + name + "\" needs a factory")
+	}
+
+	registrymu.Lock()
+	defer registrymu.Unlock()
+	if _, ok := registry[name]; ok {
+		return errors.New("Node type \"" + name + "\" is already registered")
+	}
+	registry[name] = factory
+	return nil
+}
+
+// findNodeType answers the factory registered for the name, or nil.
+func findNodeType(name string) NodeFactory {
+	registrymu.Lock()
+	defer registrymu.Unlock()
+	return registry[name]
+}
+
+// validateNode answers every problem the node reports with its values.
+// Values with variables aren't checked, so a node that can't skip them
+// isn't checked at all if it has any.
+func validateNode(n node.Node) []error {
+	if v, ok := n.(node.Validator); ok {
+		return v.Validate(hasVariable)
+	}
+	if hasVariables(n) {
+		return nil
+	}
+	if v, ok := n.(interface {
+		Validate() error
+	}); ok {
+		if err := v.Validate(); err != nil {
+			return []error{err}
+		}
+		return nil
+	}
+	if v, ok := n.(interface {
+		IsValid() bool
+	}); ok && !v.IsValid() {
+		return []error{errors.New("is not valid")}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package graph
+
+import (
+	"testing"
+
+	"github.com/hackborn/ghost/node"
+)
+
+func TestRegisterNodeType(t *testing.T) {
+	factory := func(id node.Id, name string) node.Node {
+		return &node.Exec{Id: id, Name: name}
+	}
+	cases := []struct {
+		name    string
+		factory NodeFactory
+		err     bool
+	}{
+		{name: "test_registry_node", factory: factory},
+		{name: "test_registry_node", factory: factory, err: true},
+		{name: "exec", factory: factory, err: true},
+		{name: "edge", factory: factory, err: true},
+		{name: "include", factory: factory, err: true},
+		{name: "", factory: factory, err: true},
+		{name: "test_registry_nil", err: true},
+	}
+	for _, c := range cases {
+		err := RegisterNodeType(c.name, c.factory)
+		if (err != nil) != c.err {
+			t.Errorf("RegisterNodeType(%q) error = %v, want an error %v", c.name, err, c.err)
+		}
+	}
+	if findNodeType("test_registry_node") == nil {
+		t.Errorf("findNodeType() didn't find the registered type")
+	}
+}
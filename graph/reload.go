@@ -0,0 +1,157 @@
+package graph
+
+// Rebuild a running graph when its files change.
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay is how long the files must be quiet before reloading, since
+// editors often write a file in several steps.
+const reloadDelay = 250 * time.Millisecond
+
+// Reloader runs a graph, and rebuilds it whenever the graph file or any
+// file it includes changes. The args are loaded the same way each time,
+// so they keep their values. If the changed files have errors, they're
+// printed and the current graph keeps running.
+type Reloader struct {
+	filename string
+	la       LoadArgs
+	// Watched files, by absolute name.
+	files   map[string]bool
+	watcher *fsnotify.Watcher
+
+	graphmu sync.Mutex
+	graph   *Graph
+
+	done   chan struct{}
+	waiter sync.WaitGroup
+}
+
+// NewReloader finds the graph with the given name and loads it.
+func NewReloader(n string, la LoadArgs) (*Reloader, error) {
+	filename, err := Find(n)
+	if err != nil {
+		return nil, err
+	}
+	g, files, err := loadFile(filename, la)
+	if err != nil {
+		return nil, err
+	}
+	g.printWarnings()
+	r := &Reloader{filename: filename, la: la, graph: g}
+	r.setFiles(files)
+	return r, nil
+}
+
+// Start runs the graph and begins watching its files.
+func (r *Reloader) Start() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	r.watcher = watcher
+	r.watchFiles()
+	r.done = make(chan struct{})
+	r.waiter.Add(1)
+	go r.run()
+
+	r.graphmu.Lock()
+	defer r.graphmu.Unlock()
+	return r.graph.Start()
+}
+
+// Stop ends watching the files and stops the graph.
+func (r *Reloader) Stop() {
+	if r.done != nil {
+		close(r.done)
+		r.waiter.Wait()
+		r.done = nil
+		r.watcher.Close()
+	}
+	r.graphmu.Lock()
+	defer r.graphmu.Unlock()
+	r.graph.Stop()
+}
+
+func (r *Reloader) run() {
+	defer r.waiter.Done()
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-r.done:
+			return
+		case event := <-r.watcher.Events:
+			if r.files[absGraphFile(event.Name)] && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				timer = time.After(reloadDelay)
+			}
+		case <-timer:
+			timer = nil
+			r.reload()
+		case err := <-r.watcher.Errors:
+			fmt.Println("error:", err)
+		}
+	}
+}
+
+// reload builds the graph again, replacing the running graph if there are no errors.
+func (r *Reloader) reload() {
+	g, files, err := loadFile(r.filename, r.la)
+	// Watch any new includes, even if they have errors, so fixing them reloads the graph.
+	r.setFiles(files)
+	r.watchFiles()
+	if err != nil {
+		fmt.Println("Error reloading graph, the current graph is still running:")
+		fmt.Println(err)
+		return
+	}
+
+	// Prepare the new graph first, so if it can't run the current one keeps running.
+	if err := g.Prepare(); err != nil {
+		fmt.Println("Error reloading graph, the current graph is still running:")
+		fmt.Println(err)
+		return
+	}
+	g.printWarnings()
+
+	fmt.Println("Reloading graph", r.filename)
+	r.graphmu.Lock()
+	defer r.graphmu.Unlock()
+	r.graph.Stop()
+	if err := g.Start(); err != nil {
+		fmt.Println("Error starting graph, restarting the current graph:", err)
+		if err := r.graph.Start(); err != nil {
+			fmt.Println("Error starting graph:", err)
+		}
+		return
+	}
+	r.graph = g
+}
+
+// setFiles sets the files to watch. Built-in graphs can't change, so they're skipped.
+func (r *Reloader) setFiles(files []string) {
+	r.files = make(map[string]bool)
+	for _, f := range append(files, r.filename) {
+		if !isEmbedded(f) {
+			r.files[absGraphFile(f)] = true
+		}
+	}
+}
+
+// watchFiles watches the folder of each file, so files that are
+// replaced rather than written are still noticed.
+func (r *Reloader) watchFiles() {
+	if r.watcher == nil {
+		return
+	}
+	for f := range r.files {
+		if err := r.watcher.Add(filepath.Dir(f)); err != nil {
+			fmt.Println("Can't watch graph file", f, err)
+		}
+	}
+}
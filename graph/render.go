@@ -0,0 +1,210 @@
+package graph
+
+// Draw the structure of a graph as text for diagram tools.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hackborn/ghost/node"
+)
+
+// The formats a graph can be rendered in.
+const (
+	// Graphviz DOT.
+	FormatDot = "dot"
+	// Mermaid flowchart.
+	FormatMermaid = "mermaid"
+)
+
+// graphSource is the name of the graph itself when it feeds nodes.
+const graphSource = "start"
+
+// Render loads the graph file and writes its nodes, the data edges between
+// them, and the control edges of their cmds in the given format. Missing
+// or invalid arg values aren't an error, so a graph can be drawn without
+// supplying its args.
+func Render(w io.Writer, filename string, la LoadArgs, format string) error {
+	if format != FormatDot && format != FormatMermaid {
+		return errors.New("Unknown format \"" + format + "\" (must be " + FormatDot + " or " + FormatMermaid + ")")
+	}
+	b := load(filename, la, false)
+	if errs := b.problems.Errors(); len(errs) > 0 {
+		return errs
+	}
+	d := newDiagram(b.graph, b.root.description)
+	if format == FormatMermaid {
+		return d.mermaid(w)
+	}
+	return d.dot(w)
+}
+
+// diagram is the graph reduced to labelled nodes and edges.
+type diagram struct {
+	title string
+	// True if the graph feeds any nodes.
+	start bool
+	nodes []diagramNode
+	edges []diagramEdge
+}
+
+type diagramNode struct {
+	id    string
+	label []string
+}
+
+type diagramEdge struct {
+	from, to string
+	// The named output of a data edge, or the method of a control edge.
+	label   string
+	control bool
+}
+
+func newDiagram(g *Graph, title string) diagram {
+	d := diagram{title: title}
+	ids := make(map[node.Id]string)
+	sources := make(map[node.Source]string)
+	for _, gn := range g._nodes {
+		id := fmt.Sprintf("n%v", gn.node.GetId())
+		ids[gn.node.GetId()] = id
+		sources[gn.node] = id
+	}
+	sources[g] = graphSource
+
+	for _, gn := range g._nodes {
+		id := ids[gn.node.GetId()]
+		d.nodes = append(d.nodes, diagramNode{id, nodeLabel(gn)})
+		for _, in := range gn.inputs {
+			label := ""
+			if o, ok := in.(*node.NamedOutput); ok {
+				in, label = o.Node, o.Name
+			}
+			if from, ok := sources[in]; ok {
+				d.start = d.start || from == graphSource
+				d.edges = append(d.edges, diagramEdge{from, id, label, false})
+			}
+		}
+		c, ok := gn.node.(interface {
+			GetCmds() []node.Cmd
+		})
+		if !ok {
+			continue
+		}
+		for _, cmd := range c.GetCmds() {
+			if to, ok := ids[cmd.TargetId]; ok {
+				label := cmd.Method
+				if cmd.Reply {
+					label += ", reply"
+				}
+				d.edges = append(d.edges, diagramEdge{id, to, label, true})
+			}
+		}
+	}
+	return d
+}
+
+// nodeLabel answers the lines describing the node: its name and type,
+// then what it does.
+func nodeLabel(gn graphnode) []string {
+	name := gn.name
+	if name == "" || strings.HasSuffix(name, ".") {
+		name += gn.kind
+	} else if name != gn.kind {
+		name += " (" + gn.kind + ")"
+	}
+	label := []string{name}
+	switch n := gn.node.(type) {
+	case *node.Exec:
+		if len(n.StepList) > 0 {
+			for i, step := range n.StepList {
+				label = append(label, fmt.Sprintf("%v. %v", i+1, strings.TrimSpace(step.Cmd+" "+step.Args)))
+			}
+		} else {
+			label = append(label, strings.TrimSpace(n.Cmd+" "+n.Args))
+		}
+		if n.Dir != "" {
+			label = append(label, "in "+n.Dir)
+		}
+	case *node.Watch:
+		for _, f := range n.Folders {
+			if f.Filter != "" {
+				label = append(label, f.Path+" ("+f.Filter+")")
+			} else {
+				label = append(label, f.Path)
+			}
+		}
+	}
+	return label
+}
+
+func (d diagram) dot(w io.Writer) error {
+	escape := func(s string) string {
+		s = strings.Replace(s, "\\", "\\\\", -1)
+		return strings.Replace(s, "\"", "\\\"", -1)
+	}
+	quote := func(s string) string {
+		return "\"" + escape(s) + "\""
+	}
+	var lines []string
+	lines = append(lines, "digraph ghost {")
+	if d.title != "" {
+		lines = append(lines, "\tlabel="+quote(d.title)+";")
+	}
+	lines = append(lines, "\tnode [shape=box];")
+	if d.start {
+		lines = append(lines, "\t"+graphSource+" [shape=circle];")
+	}
+	for _, n := range d.nodes {
+		var label []string
+		for _, l := range n.label {
+			label = append(label, escape(l))
+		}
+		lines = append(lines, "\t"+n.id+" [label=\""+strings.Join(label, "\\n")+"\"];")
+	}
+	for _, e := range d.edges {
+		if e.control {
+			lines = append(lines, "\t"+e.from+" -> "+e.to+" [style=dashed, label="+quote(e.label)+"];")
+		} else if e.label != "" {
+			lines = append(lines, "\t"+e.from+" -> "+e.to+" [label="+quote(e.label)+"];")
+		} else {
+			lines = append(lines, "\t"+e.from+" -> "+e.to+";")
+		}
+	}
+	lines = append(lines, "}")
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+func (d diagram) mermaid(w io.Writer) error {
+	quote := func(s string) string {
+		return "\"" + strings.Replace(s, "\"", "#quot;", -1) + "\""
+	}
+	var lines []string
+	if d.title != "" {
+		lines = append(lines, "---", "title: "+quote(d.title), "---")
+	}
+	lines = append(lines, "flowchart TD")
+	if d.start {
+		lines = append(lines, "\t"+graphSource+"((start))")
+	}
+	for _, n := range d.nodes {
+		var label []string
+		for _, l := range n.label {
+			label = append(label, strings.Replace(l, "\"", "#quot;", -1))
+		}
+		lines = append(lines, "\t"+n.id+"[\""+strings.Join(label, "<br/>")+"\"]")
+	}
+	for _, e := range d.edges {
+		if e.control {
+			lines = append(lines, "\t"+e.from+" -.->|"+quote(e.label)+"| "+e.to)
+		} else if e.label != "" {
+			lines = append(lines, "\t"+e.from+" -->|"+quote(e.label)+"| "+e.to)
+		} else {
+			lines = append(lines, "\t"+e.from+" --> "+e.to)
+		}
+	}
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
@@ -0,0 +1,235 @@
+package graph
+
+// Replace the variables and functions in graph strings.
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// function is applied to the values of its arguments in a ${name:args} variable.
+type function struct {
+	// Split the text after the colon into arguments.
+	split func(s string) []string
+	// Answer the value from the arguments. A missing variable is passed as
+	// nil, so functions like default can handle it.
+	apply func(args []*string) (string, bool)
+}
+
+// functions are the functions available to variables, i.e. ${dir:watch}.
+// Arguments are the names of args, macros or built-in variables, except for
+// the fallback of default, which is literal text.
+var functions = map[string]function{
+	"dir":     unary(filepath.Dir),
+	"base":    unary(filepath.Base),
+	"lower":   unary(strings.ToLower),
+	"trimext": unary(func(s string) string { return strings.TrimSuffix(s, filepath.Ext(s)) }),
+	"join": {
+		func(s string) []string { return strings.Split(s, ",") },
+		func(args []*string) (string, bool) {
+			var elems []string
+			for _, a := range args {
+				if a == nil {
+					return "", false
+				}
+				elems = append(elems, *a)
+			}
+			return filepath.Join(elems...), true
+		},
+	},
+}
+
+// The default function is only split on the first |, and its fallback isn't looked up.
+const defaultFunction = "default"
+
+// unary answers a function of a single argument.
+func unary(fn func(string) string) function {
+	return function{
+		func(s string) []string { return []string{s} },
+		func(args []*string) (string, bool) {
+			if len(args) != 1 || args[0] == nil {
+				return "", false
+			}
+			return fn(*args[0]), true
+		},
+	}
+}
+
+// splitFunction() answers the function name and argument names in the
+// variable, or false if the variable isn't a function.
+func splitFunction(v string) (string, []string, bool) {
+	i := strings.Index(v, ":")
+	if i < 0 {
+		return "", nil, false
+	}
+	name, rest := v[:i], v[i+1:]
+	if name == defaultFunction {
+		args := strings.SplitN(rest, "|", 2)
+		args[0] = strings.TrimSpace(args[0])
+		return name, args, true
+	}
+	if fn, ok := functions[name]; ok {
+		args := fn.split(rest)
+		for i := range args {
+			args[i] = strings.TrimSpace(args[i])
+		}
+		return name, args, true
+	}
+	return "", nil, false
+}
+
+// variableNames() answers the names of the variables used by the variable,
+// which are the arguments if it's a function.
+func variableNames(v string) []string {
+	name, args, ok := splitFunction(v)
+	if !ok {
+		return []string{v}
+	}
+	if name == defaultFunction {
+		return args[:1]
+	}
+	return args
+}
+
+// ChangeString replaces each variable with its value, leaving any that
+// can't be found, and escaped ones, unchanged. Values are not themselves
+// expanded.
+func (l *level) ChangeString(s string) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+	return variableRe.ReplaceAllStringFunc(s, func(v string) string {
+		if escaped(v) {
+			return v
+		}
+		if value, ok := l.evaluate(v[2 : len(v)-1]); ok {
+			return value
+		}
+		return v
+	})
+}
+
+// evaluate() answers the value of a variable or function.
+func (l *level) evaluate(v string) (string, bool) {
+	name, args, ok := splitFunction(v)
+	if !ok {
+		return l.value(v)
+	}
+	if name == defaultFunction {
+		if value, ok := l.value(args[0]); ok && value != "" {
+			return value, true
+		}
+		if len(args) > 1 {
+			return args[1], true
+		}
+		return "", true
+	}
+	values := make([]*string, len(args))
+	for i, a := range args {
+		if value, ok := l.value(a); ok {
+			values[i] = &value
+		}
+	}
+	return functions[name].apply(values)
+}
+
+// value() answers the value of an arg, macro or built-in variable.
+func (l *level) value(name string) (string, bool) {
+	if v, ok := l.lookup(name); ok {
+		return v, true
+	}
+	return builtins{l.file}.lookup(name)
+}
+
+// resolveMacros replaces the variables in the macros of the level. Macros
+// can use each other in any order, so they're replaced in dependency
+// order, and any that depend on themselves are reported and emptied.
+func (b *builder) resolveMacros(l *level) {
+	list := l.macros.List
+	index := make(map[string]int)
+	for i, m := range list {
+		if !l.args.has(m.XMLName.Local) {
+			index[m.XMLName.Local] = i
+		}
+	}
+	// The macros each macro uses.
+	deps := make([][]int, len(list))
+	scan := func(i int) variableScan {
+		return variableScan{func(v string) {
+			for _, name := range variableNames(v) {
+				if d, ok := index[name]; ok {
+					deps[i] = append(deps[i], d)
+				}
+			}
+		}}
+	}
+	for i, m := range list {
+		scan(i).ChangeString(m.Value)
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		resolved
+	)
+	state := make([]int, len(list))
+	var path []int
+	var visit func(i int)
+	visit = func(i int) {
+		switch state[i] {
+		case resolved:
+			return
+		case visiting:
+			b.reportMacroCycle(l, path, i)
+			return
+		}
+		state[i] = visiting
+		path = append(path, i)
+		for _, d := range deps[i] {
+			visit(d)
+		}
+		path = path[:len(path)-1]
+		if state[i] == visiting {
+			list[i].Value = l.ChangeString(list[i].Value)
+			state[i] = resolved
+		}
+	}
+	for i := range list {
+		visit(i)
+	}
+}
+
+// reportMacroCycle reports the macros on the path from i back to i, and
+// empties them so they aren't also reported as undefined.
+func (b *builder) reportMacroCycle(l *level, path []int, i int) {
+	start := 0
+	for start < len(path) && path[start] != i {
+		start++
+	}
+	var names []string
+	for _, p := range path[start:] {
+		names = append(names, l.macros.List[p].XMLName.Local)
+	}
+	names = append(names, l.macros.List[i].XMLName.Local)
+	b.errorAt(posAt(l.macroPos, i), "Macro cycle: %v", strings.Join(names, " -> "))
+	for _, p := range path[start:] {
+		l.macros.List[p].Value = ""
+	}
+}
+
+// undefinedMsg answers the error for a variable that couldn't be replaced.
+func (l *level) undefinedMsg(v string) string {
+	name, args, ok := splitFunction(v)
+	if !ok {
+		if i := strings.Index(v, ":"); i > 0 && !strings.HasPrefix(v, envPrefix) {
+			return "Unknown function \"" + v[:i] + "\" in ${" + v + "}"
+		}
+		return undefinedMsg(v, l.file)
+	}
+	for _, a := range variableNames(v) {
+		if _, ok := l.value(a); !ok {
+			return undefinedMsg(a, l.file) + " in ${" + v + "}"
+		}
+	}
+	return "Function \"" + name + "\" can't use " + strings.Join(args, ", ") + " in ${" + v + "}"
+}
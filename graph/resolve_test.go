@@ -0,0 +1,97 @@
+package graph
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hackborn/ghost/node"
+)
+
+func TestResolveMacros(t *testing.T) {
+	cases := []struct {
+		name   string
+		macros string
+		args   string
+		want   string
+	}{
+		{name: "any order", macros: `<bin>${dir}/${app}</bin><app>${name}</app><dir>out</dir><name>srv</name>`,
+			args: "${bin}", want: "out/srv"},
+		{name: "args", macros: `<mode>-${level}</mode>`, args: "${mode}", want: "-debug"},
+		{name: "dir", macros: `<src>${join:top,sub,file}</src>`, args: "${dir:src}", want: filepath.Join("a", "b")},
+		{name: "base", macros: `<src>${join:top,file}</src>`, args: "${base:src}", want: "Main.go"},
+		{name: "lower", macros: `<up>${file}</up>`, args: "${lower:up}", want: "main.go"},
+		{name: "trimext", args: "${trimext:file}", want: "Main"},
+		{name: "default", args: "${default:level|info} ${default:empty|info} ${default:nope|a b}",
+			want: "debug info a b"},
+		{name: "in macros", macros: `<out>${trimext:file}.exe</out>`, args: "${out}", want: "Main.exe"},
+	}
+	dir := t.TempDir()
+	for _, c := range cases {
+		graph := `<graph>
+	<args>
+		<level>debug</level>
+		<empty />
+		<nope />
+	</args>
+	<macros>
+		<top>a</top>
+		<sub>b</sub>
+		<file>Main.go</file>
+		` + c.macros + `
+	</macros>
+	<nodes>
+		<exec cmd="x" args="` + c.args + `" />
+	</nodes>
+</graph>`
+		b := load(writeGraph(t, dir, "g.xml", graph), setArgs(map[string]string{"nope": ""}), true)
+		if errs := b.problems.Errors(); len(errs) > 0 {
+			t.Errorf("%v: problems %v", c.name, problemList(errs))
+			continue
+		}
+		if got := b.graph._nodes[0].node.(*node.Exec).Args; got != c.want {
+			t.Errorf("%v: args = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		macros string
+		args   string
+		want   []string
+	}{
+		{name: "cycle", macros: `
+		<a>${b}</a>
+		<b>${a}</b>`, want: []string{`3: Macro cycle: a -> b -> a`}},
+		{name: "longer cycle", macros: `
+		<ok>fine</ok>
+		<a>${ok}${b}</a>
+		<b>${c}</b>
+		<c>x/${a}</c>`, want: []string{`4: Macro cycle: a -> b -> c -> a`}},
+		{name: "self", macros: `
+		<a>${a}</a>`, want: []string{`3: Macro cycle: a -> a`}},
+		{name: "cycle through a function", macros: `
+		<a>${dir:b}</a>
+		<b>${join:a,c}</b>
+		<c>x</c>`, want: []string{`3: Macro cycle: a -> b -> a`}},
+		{name: "unknown function", args: "${upper:x}", want: []string{`5: Unknown function "upper" in ${upper:x}`}},
+		{name: "missing function arg", macros: `
+		<x>a</x>`, args: "${join:x,y}", want: []string{`6: Undefined variable ${y} in ${join:x,y}`}},
+	}
+	dir := t.TempDir()
+	for _, c := range cases {
+		graph := `<graph>
+	<macros>` + c.macros + `
+	</macros>
+	<nodes>
+		<exec cmd="x" args="` + c.args + `" />
+	</nodes>
+</graph>`
+		b := load(writeGraph(t, dir, "g.xml", graph), nil, true)
+		if got := problemList(b.problems); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: problems = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
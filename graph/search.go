@@ -0,0 +1,62 @@
+package graph
+
+// Locate the folders that contain graph files.
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/kardianos/osext"
+)
+
+const (
+	// Environment variable with a list of folders to search for graphs.
+	GraphPathEnv = "GHOST_GRAPH_PATH"
+	// Name of the project-local folder of graphs.
+	projectDir = ".ghost"
+)
+
+// SearchPath answers the folders searched for graphs, in order of precedence:
+// 1. Every folder in the GHOST_GRAPH_PATH environment variable.
+// 2. The nearest .ghost folder in the current directory or any parent.
+// 3. The ghost/graphs folder in the user config directory (i.e. ~/.config/ghost/graphs).
+// 4. The data/graphs folder next to the executable.
+// After these, the default graphs compiled into the binary are searched.
+func SearchPath() []string {
+	var dirs []string
+	for _, p := range filepath.SplitList(os.Getenv(GraphPathEnv)) {
+		if p != "" {
+			dirs = append(dirs, p)
+		}
+	}
+	if p, err := findProjectDir(); err == nil {
+		dirs = append(dirs, p)
+	}
+	if p, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(p, "ghost", "graphs"))
+	}
+	if p, err := osext.ExecutableFolder(); err == nil {
+		dirs = append(dirs, filepath.Join(p, "data", "graphs"))
+	}
+	return dirs
+}
+
+// findProjectDir answers the nearest .ghost folder, walking up from the
+// current directory.
+func findProjectDir() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		p := filepath.Join(dir, projectDir)
+		if info, err := os.Stat(p); err == nil && info.IsDir() {
+			return p, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", os.ErrNotExist
+		}
+		dir = parent
+	}
+}
@@ -14,6 +14,14 @@ type Arg struct {
 	XMLName xml.Name
 	Value   string `xml:",chardata"`
 	Usage   string `xml:"usage,attr"`
+	// When true, the graph won't load unless the arg has a value.
+	Required bool `xml:"required,attr"`
+	// The value if none is supplied. This is the same as the element text.
+	Default string `xml:"default,attr"`
+	// The type of the value, one of the ArgType constants. Defaults to string.
+	Type string `xml:"type,attr"`
+	// The comma-separated values allowed for an enum.
+	Choices string `xml:"choices,attr"`
 	// This has been added to Go1.8. When that's released, I can
 	// use this to simplify (i.e. eliminate) a lot of the parsing.
 	//	Attrs   []xml.Attr `xml:",any,attr"`
@@ -41,6 +49,21 @@ func (a Args) ChangeString(s string) string {
 	return s
 }
 
+// get() answers the value of the named arg.
+func (a Args) get(name string) (string, bool) {
+	for _, v := range a.Arg {
+		if v.XMLName.Local == name {
+			return v.Value, true
+		}
+	}
+	return "", false
+}
+
+func (a Args) has(name string) bool {
+	_, ok := a.get(name)
+	return ok
+}
+
 func (m Macros) ChangeString(s string) string {
 	for _, v := range m.List {
 		s = strings.Replace(s, "${"+v.XMLName.Local+"}", v.Value, -1)
@@ -48,14 +71,25 @@ func (m Macros) ChangeString(s string) string {
 	return s
 }
 
-type LoadArgs func(*Args)
+// LoadArgs is a function that supplies values to the graph args,
+// typically from the command line.
+type LoadArgs func(*Args) error
 
 // The complete graph.
 type Graph struct {
-	Args   Args
-	Macros Macros
+	// A one-line summary of what the graph does.
+	Description string
+	Args        Args
+	Macros      Macros
+	// Environment variables for the processes of every node.
+	Env []node.EnvVar
 	// All nodes that were created for the graph.
 	_nodes []graphnode
+	// True once the nodes have constructed their data for the next start.
+	prepared bool
+	// The warnings found while loading, which are printed once the
+	// graph is known to be usable.
+	warnings Problems
 	// Control whether the nodes should be running. When this is closed,
 	// all funcs should stop.
 	done chan struct{}
@@ -117,9 +151,11 @@ func (g *Graph) SendMsg(msg node.Msg, to node.Id) error {
 	return g.control.sendMsg(msg, to)
 }
 
-func (g *Graph) add(n node.Node) {
+func (g *Graph) add(n node.Node, kind, name string) {
 	var gn graphnode
 	gn.node = n
+	gn.kind = kind
+	gn.name = name
 	g._nodes = append(g._nodes, gn)
 }
 
@@ -133,25 +169,60 @@ func (g *Graph) addInput(n node.Node, s node.Source) {
 	}
 }
 
-func (g *Graph) Start() error {
-	g.Stop()
-
-	g.done = make(chan struct{})
-	if g.done == nil {
-		return errors.New("Can't make done channel")
-	}
-
-	// Construct all node data
+// Prepare constructs the data each node needs to run, answering an error
+// if any node can't run. Start prepares the graph if it isn't already,
+// so this can check a graph before stopping another that's running.
+func (g *Graph) Prepare() error {
+	g.prepared = false
 	for i := 0; i < len(g._nodes); i++ {
 		n := &(g._nodes[i])
 		if n.node != nil && len(n.inputs) > 0 {
 			data, err := n.node.PrepareToStart(g, n.inputs)
 			if err != nil {
+				g.release()
 				return err
 			}
 			n.prepare = data
 		}
 	}
+	g.prepared = true
+	return nil
+}
+
+// printWarnings() prints the warnings found while loading the graph.
+func (g *Graph) printWarnings() {
+	for _, p := range g.warnings {
+		fmt.Println(p)
+	}
+}
+
+// release() releases the data of the nodes that were prepared, for a
+// graph that won't start.
+func (g *Graph) release() {
+	for i := 0; i < len(g._nodes); i++ {
+		n := &(g._nodes[i])
+		if r, ok := n.node.(node.Releaser); ok && n.prepare != nil {
+			r.ReleasePrepared(n.prepare)
+		}
+		n.prepare = nil
+	}
+}
+
+func (g *Graph) Start() error {
+	g.Stop()
+
+	// Construct all node data
+	if !g.prepared {
+		if err := g.Prepare(); err != nil {
+			return err
+		}
+	}
+	g.prepared = false
+
+	g.done = make(chan struct{})
+	if g.done == nil {
+		return errors.New("Can't make done channel")
+	}
 
 	// Start each node
 	for i := 0; i < len(g._nodes); i++ {
@@ -184,7 +255,10 @@ func (g *Graph) Stop() {
 
 // Describe the structure of the graph.
 type graphnode struct {
-	node   node.Node
+	node node.Node
+	// The element name of the node, and its name including any include prefix.
+	kind   string
+	name   string
 	inputs []node.Source
 	// Data generated from node.PrepareToStart
 	prepare interface{}
@@ -0,0 +1,557 @@
+package graph
+
+// Find and report problems in graph files.
+
+import (
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hackborn/ghost/node"
+)
+
+// Problem is a single issue found while loading a graph file.
+type Problem struct {
+	File string
+	// The line of the problem, or 0 if unknown.
+	Line int
+	// Warnings are reported but don't prevent the graph from loading.
+	Warning bool
+	Msg     string
+}
+
+func (p Problem) String() string {
+	pos := p.File
+	if p.Line > 0 {
+		pos += ":" + strconv.Itoa(p.Line)
+	}
+	kind := "error"
+	if p.Warning {
+		kind = "warning"
+	}
+	return pos + ": " + kind + ": " + p.Msg
+}
+
+// Problems is a list of problems, and an error reporting each one.
+type Problems []Problem
+
+func (ps Problems) Error() string {
+	var lines []string
+	for _, p := range ps {
+		lines = append(lines, p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Errors answers the problems that aren't warnings.
+func (ps Problems) Errors() Problems {
+	var errs Problems
+	for _, p := range ps {
+		if !p.Warning {
+			errs = append(errs, p)
+		}
+	}
+	return errs
+}
+
+// Validate loads and builds the graph file without running it,
+// answering every problem found. The arg values are only checked
+// if they're supplied, i.e. la is not nil.
+func Validate(filename string, la LoadArgs) Problems {
+	return load(filename, la, la != nil).problems
+}
+
+// errorf reports an error at a line in the file currently being decoded.
+func (b *builder) errorf(line int, format string, args ...interface{}) {
+	b.report(b.pos(line), false, fmt.Sprintf(format, args...))
+}
+
+func (b *builder) errorAt(pos position, format string, args ...interface{}) {
+	b.report(pos, false, fmt.Sprintf(format, args...))
+}
+
+func (b *builder) warnAt(pos position, format string, args ...interface{}) {
+	b.report(pos, true, fmt.Sprintf(format, args...))
+}
+
+func (b *builder) report(pos position, warning bool, msg string) {
+	b.problems = append(b.problems, Problem{pos.file, pos.line, warning, msg})
+}
+
+// decodeError reports an error from reading the XML.
+func (b *builder) decodeError(err error) {
+	if serr, ok := err.(*xml.SyntaxError); ok {
+		b.errorf(serr.Line, "%v", serr.Msg)
+	} else {
+		b.errorf(0, "%v", err)
+	}
+}
+
+// decodeElement decodes the element into v, answering false
+// and reporting the problem on failure.
+func (b *builder) decodeElement(e *element, v interface{}) bool {
+	err := e.decode(v)
+	if err != nil {
+		b.errorf(e.lines[0], "<%v> %v", e.start().Name.Local, err)
+		return false
+	}
+	return true
+}
+
+// -----------------------------------------------
+// element struct
+// The complete token stream of a single element, along with the line of each
+// token, so it can be checked before it's decoded.
+type element struct {
+	tokens []xml.Token
+	lines  []int
+	next   int
+}
+
+// readElement reads the rest of the element that begins with start,
+// answering nil and reporting the problem on failure.
+func (b *builder) readElement(decoder *xml.Decoder, start xml.StartElement, line int) *element {
+	e := &element{}
+	e.add(start, line)
+	for depth := 1; depth > 0; {
+		token, err := decoder.Token()
+		if err != nil {
+			b.decodeError(err)
+			return nil
+		}
+		line, _ := decoder.InputPos()
+		switch token.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+		e.add(token, line)
+	}
+	return e
+}
+
+func (e *element) add(token xml.Token, line int) {
+	e.tokens = append(e.tokens, xml.CopyToken(token))
+	e.lines = append(e.lines, line)
+}
+
+func (e *element) start() xml.StartElement {
+	return e.tokens[0].(xml.StartElement)
+}
+
+// childLines answers the line of each direct child element.
+func (e *element) childLines() []int {
+	var lines []int
+	depth := 0
+	for i, t := range e.tokens {
+		switch t.(type) {
+		case xml.StartElement:
+			if depth == 1 {
+				lines = append(lines, e.lines[i])
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return lines
+}
+
+// Token is the xml.TokenReader interface.
+func (e *element) Token() (xml.Token, error) {
+	if e.next >= len(e.tokens) {
+		return nil, fmt.Errorf("unexpected end of element")
+	}
+	e.next++
+	return e.tokens[e.next-1], nil
+}
+
+func (e *element) decode(v interface{}) error {
+	e.next = 0
+	return xml.NewTokenDecoder(e).Decode(v)
+}
+
+// -----------------------------------------------
+// schema struct
+// The attributes and child elements an element accepts, taken from the
+// xml tags of the struct it decodes into.
+type schema struct {
+	attrs    map[string]bool
+	children map[string]*schema
+	// The schema for any child element, if the struct accepts any.
+	anyChild *schema
+}
+
+var (
+	schemamu sync.Mutex
+	schemas  = map[reflect.Type]*schema{}
+)
+
+// schemaFor answers the schema for the value's type.
+func schemaFor(v interface{}) *schema {
+	schemamu.Lock()
+	defer schemamu.Unlock()
+	return schemaForType(reflect.TypeOf(v))
+}
+
+func schemaForType(t reflect.Type) *schema {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if s, ok := schemas[t]; ok {
+		return s
+	}
+	s := &schema{attrs: make(map[string]bool), children: make(map[string]*schema)}
+	schemas[t] = s
+	s.addFields(t)
+	return s
+}
+
+func (s *schema) addFields(t reflect.Type) {
+	if t.Kind() != reflect.Struct {
+		return
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("xml")
+		if f.Anonymous && tag == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			s.addFields(ft)
+			continue
+		}
+		if tag == "" || tag == "-" || f.PkgPath != "" || f.Name == "XMLName" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		name := parts[0]
+		flags := "," + strings.Join(parts[1:], ",") + ","
+		if strings.Contains(flags, ",attr,") {
+			if name == "" {
+				name = f.Name
+			}
+			s.attrs[name] = true
+		} else if strings.Contains(flags, ",any,") {
+			s.anyChild = schemaForType(f.Type)
+		} else if len(parts) == 1 || name != "" {
+			if name == "" {
+				name = f.Name
+			}
+			// Nested paths (a>b) aren't checked past the first element.
+			if i := strings.Index(name, ">"); i >= 0 {
+				s.children[name[:i]] = nil
+			} else {
+				s.children[name] = schemaForType(f.Type)
+			}
+		}
+	}
+}
+
+// child answers the schema for the named child, and false if the child isn't allowed.
+func (s *schema) child(name string) (*schema, bool) {
+	if c, ok := s.children[name]; ok {
+		return c, true
+	}
+	if s.anyChild != nil {
+		return s.anyChild, true
+	}
+	return nil, false
+}
+
+// lint reports any attribute or child element the schema doesn't accept.
+// The extra attributes are also allowed on the root element.
+func (b *builder) lint(e *element, root *schema, extra ...string) {
+	var stack []*schema
+	var names []string
+	for i, t := range e.tokens {
+		switch tok := t.(type) {
+		case xml.StartElement:
+			name := tok.Name.Local
+			s := root
+			if len(stack) > 0 {
+				s = nil
+				if parent := stack[len(stack)-1]; parent != nil {
+					var ok bool
+					s, ok = parent.child(name)
+					if !ok {
+						b.errorf(e.lines[i], "Unknown element <%v> in <%v>", name, names[len(names)-1])
+					}
+				}
+			}
+			if s != nil {
+				for _, a := range tok.Attr {
+					if a.Name.Space == "xmlns" || a.Name.Local == "xmlns" || s.attrs[a.Name.Local] {
+						continue
+					}
+					if len(stack) == 0 && containsString(extra, a.Name.Local) {
+						continue
+					}
+					b.errorf(e.lines[i], "Unknown attribute \"%v\" on <%v>", a.Name.Local, name)
+				}
+			}
+			stack = append(stack, s)
+			names = append(names, name)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+			names = names[:len(names)-1]
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// -----------------------------------------------
+// Variables
+
+// variableRe matches a variable, or an escaped one like $${HOME}.
+var variableRe = regexp.MustCompile(`\$?\$\{([^}]*)\}`)
+
+// escaped answers true if the match is an escaped variable, which
+// isn't replaced, but left as ${name} for the command or shell.
+func escaped(match string) bool {
+	return strings.HasPrefix(match, "$$")
+}
+
+// unescape answers the string with each escaped variable left as ${name}.
+func unescape(s string) string {
+	if !strings.Contains(s, "$${") {
+		return s
+	}
+	return variableRe.ReplaceAllStringFunc(s, func(v string) string {
+		if escaped(v) {
+			return v[1:]
+		}
+		return v
+	})
+}
+
+// unescaper is a ChangeString that unescapes the strings.
+type unescaper struct{}
+
+func (unescaper) ChangeString(s string) string {
+	return unescape(s)
+}
+
+// variableScan is a ChangeString that reports each variable
+// in the strings, leaving them unchanged.
+type variableScan struct {
+	found func(name string)
+}
+
+func (v variableScan) ChangeString(s string) string {
+	for _, m := range variableRe.FindAllStringSubmatch(s, -1) {
+		if !escaped(m[0]) {
+			v.found(m[1])
+		}
+	}
+	return s
+}
+
+// checkNames reports args and macros that share a name, and any
+// that hide a built-in variable.
+func (b *builder) checkNames(l *level) {
+	for i, a := range l.args.Arg {
+		if isBuiltin(a.Name()) {
+			b.warnAt(posAt(l.argPos, i), "Arg \"%v\" hides the built-in variable ${%v}", a.Name(), a.Name())
+		}
+	}
+	for i, m := range l.macros.List {
+		if l.args.has(m.XMLName.Local) {
+			b.errorAt(posAt(l.macroPos, i), "Macro \"%v\" has the same name as an arg", m.XMLName.Local)
+		} else if isBuiltin(m.XMLName.Local) {
+			b.warnAt(posAt(l.macroPos, i), "Macro \"%v\" hides the built-in variable ${%v}", m.XMLName.Local, m.XMLName.Local)
+		}
+	}
+	for _, u := range l.units {
+		if u.sub != nil {
+			b.checkNames(u.sub)
+		}
+	}
+}
+
+// applyDefaults sets the value of any arg without one to its default.
+func (b *builder) applyDefaults(l *level) {
+	for i := 0; i < len(l.args.Arg); i++ {
+		a := &l.args.Arg[i]
+		if a.Value == "" {
+			a.Value = a.Default
+		}
+	}
+	for _, u := range l.units {
+		if u.sub != nil {
+			b.applyDefaults(u.sub)
+		}
+	}
+}
+
+// checkArgTypes reports any arg with an unknown type.
+func (b *builder) checkArgTypes(l *level) {
+	for i := 0; i < len(l.args.Arg); i++ {
+		if err := l.args.Arg[i].checkType(); err != nil {
+			b.errorAt(posAt(l.argPos, i), "%v", err)
+		}
+	}
+	for _, u := range l.units {
+		if u.sub != nil {
+			b.checkArgTypes(u.sub)
+		}
+	}
+}
+
+// checkArgValues reports any arg in the level that's missing or has
+// the wrong type of value.
+func (b *builder) checkArgValues(l *level) {
+	for i := 0; i < len(l.args.Arg); i++ {
+		a := &l.args.Arg[i]
+		if a.checkType() != nil {
+			continue
+		}
+		if err := a.checkValue(); err != nil {
+			b.errorAt(posAt(l.argPos, i), "%v", err)
+		}
+	}
+}
+
+// findRefs answers the names of every variable used in the macros,
+// env, log folder, include bindings and nodes.
+func (b *builder) findRefs() map[string]bool {
+	refs := make(map[string]bool)
+	scan := variableScan{func(v string) {
+		for _, name := range variableNames(v) {
+			refs[name] = true
+		}
+	}}
+	var findLevel func(l *level)
+	findLevel = func(l *level) {
+		for _, m := range l.macros.List {
+			scan.ChangeString(m.Value)
+		}
+		for _, v := range *l.env {
+			scan.ChangeString(v.Value)
+		}
+		scan.ChangeString(l.logDir)
+		for _, u := range l.units {
+			if u.node != nil {
+				u.node.ApplyArgs(scan)
+			} else {
+				for _, a := range u.bindings.Arg {
+					scan.ChangeString(a.Value)
+				}
+				findLevel(u.sub)
+			}
+		}
+	}
+	findLevel(b.root)
+	return refs
+}
+
+// checkUndefined reports any variables or functions that couldn't be replaced.
+// One that's left in a macro is also left in every value that uses the
+// macro, so it's only reported at the macro.
+func (b *builder) checkUndefined() {
+	check := func(l *level, pos position, inMacros map[string]bool, macro bool) node.ChangeString {
+		reported := make(map[string]bool)
+		return variableScan{func(v string) {
+			if !reported[v] && !inMacros[v] {
+				reported[v] = true
+				b.errorAt(pos, "%v", l.undefinedMsg(v))
+				if macro {
+					inMacros[v] = true
+				}
+			}
+		}}
+	}
+	var checkLevel func(l *level, parentMacros map[string]bool)
+	checkLevel = func(l *level, parentMacros map[string]bool) {
+		inMacros := make(map[string]bool)
+		for v := range parentMacros {
+			inMacros[v] = true
+		}
+		for i, m := range l.macros.List {
+			check(l, posAt(l.macroPos, i), inMacros, true).ChangeString(m.Value)
+		}
+		for i, v := range *l.env {
+			check(l, posAt(l.envPos, i), inMacros, false).ChangeString(v.Value)
+		}
+		check(l, l.logDirPos, inMacros, false).ChangeString(l.logDir)
+		for _, u := range l.units {
+			if u.node != nil {
+				u.node.ApplyArgs(check(l, u.pos, inMacros, false))
+			} else {
+				checkLevel(u.sub, inMacros)
+			}
+		}
+	}
+	checkLevel(b.root, nil)
+}
+
+// hasVariables answers true if any value of the node has a variable.
+func hasVariables(n node.Node) bool {
+	found := false
+	n.ApplyArgs(variableScan{func(string) {
+		found = true
+	}})
+	return found
+}
+
+// hasVariable answers true if the string has a variable.
+func hasVariable(s string) bool {
+	for _, m := range variableRe.FindAllString(s, -1) {
+		if !escaped(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateNodes reports every problem with the values of the nodes.
+// Values with variables aren't checked: either they're undefined, which
+// was already reported, or the arg values aren't known.
+func (b *builder) validateNodes() {
+	for _, u := range b.units {
+		for _, err := range validateNode(u.node) {
+			b.errorAt(u.pos, "<%v> %v", u.kind, err)
+		}
+	}
+}
+
+// checkUnused reports any args and macros that are never referenced.
+// Included args with a value from the include don't need to be referenced.
+func (b *builder) checkUnused(l *level, refs map[string]bool) {
+	for i, a := range l.args.Arg {
+		if !refs[a.XMLName.Local] {
+			b.warnAt(posAt(l.argPos, i), "Arg \"%v\" is never used", a.XMLName.Local)
+		}
+	}
+	for i, m := range l.macros.List {
+		if !refs[m.XMLName.Local] {
+			b.warnAt(posAt(l.macroPos, i), "Macro \"%v\" is never used", m.XMLName.Local)
+		}
+	}
+	for _, u := range l.units {
+		if u.sub != nil {
+			b.checkUnused(u.sub, refs)
+		}
+	}
+}
+
+func posAt(list []position, i int) position {
+	if i >= 0 && i < len(list) {
+		return list[i]
+	}
+	return position{}
+}
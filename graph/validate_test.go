@@ -0,0 +1,115 @@
+package graph
+
+import (
+	"reflect"
+	"testing"
+)
+
+// setArgs answers LoadArgs that set the values of the named args.
+func setArgs(values map[string]string) LoadArgs {
+	return func(args *Args) error {
+		for i := range args.Arg {
+			if v, ok := values[args.Arg[i].Name()]; ok {
+				args.Arg[i].Value = v
+			}
+		}
+		return nil
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		graph string
+		la    LoadArgs
+		want  []string
+	}{
+		{name: "lint", graph: `<graph>
+	<nodes>
+		<exec cmd="go" autorn="true">
+			<lg>hi</lg>
+		</exec>
+		<watch><folder filtr=".go">/src</folder></watch>
+		<bogus />
+	</nodes>
+	<extra />
+</graph>`, want: []string{
+			`3: Unknown attribute "autorn" on <exec>`,
+			`4: Unknown element <lg> in <exec>`,
+			`6: Unknown attribute "filtr" on <folder>`,
+			`7: Unknown node type <bogus>`,
+			`9: Unknown element <extra>`,
+		}},
+		{name: "undefined", graph: `<graph>
+	<macros>
+		<bin>${typo}/app</bin>
+	</macros>
+	<nodes>
+		<exec cmd="${bin}" args="${nope} ${nope} ${upper:bin}" />
+		<exec cmd="x" args="${join:bin,missing}" />
+	</nodes>
+</graph>`, want: []string{
+			`3: Undefined variable ${typo}`,
+			`6: Undefined variable ${nope}`,
+			`6: Unknown function "upper" in ${upper:bin}`,
+			`7: Undefined variable ${missing} in ${join:bin,missing}`,
+		}},
+		{name: "escaped", graph: `<graph>
+	<nodes>
+		<exec cmd="echo" args="$${HOME} $${nope}" shell="true" />
+	</nodes>
+</graph>`},
+		{name: "every exec problem", graph: `<graph>
+	<nodes>
+		<exec args="no cmd" merge="some" timeout="soon" stop_timeout="-1s" />
+	</nodes>
+</graph>`, want: []string{
+			`3: <exec> requires a cmd`,
+			`3: <exec> unknown merge policy "some" (must be any, all or latest)`,
+			`3: <exec> stop_timeout "-1s" must be a duration, i.e. "10s"`,
+			`3: <exec> timeout "soon" must be a duration, i.e. "30s"`,
+		}},
+		{name: "unknown args", graph: `<graph>
+	<args>
+		<wait usage="How long" type="duration" />
+	</args>
+	<nodes>
+		<exec cmd="sleep" timeout="${wait}" max_restarts="many" />
+	</nodes>
+</graph>`, want: []string{
+			`6: <exec> max_restarts "many" must be a number, 0 for no limit`,
+		}},
+		{name: "known args", graph: `<graph>
+	<args>
+		<wait usage="How long" />
+	</args>
+	<nodes>
+		<exec cmd="sleep" timeout="${wait}" max_restarts="many" />
+	</nodes>
+</graph>`, la: setArgs(map[string]string{"wait": "soon"}), want: []string{
+			`6: <exec> timeout "soon" must be a duration, i.e. "30s"`,
+			`6: <exec> max_restarts "many" must be a number, 0 for no limit`,
+		}},
+		{name: "arg values", graph: `<graph>
+	<args>
+		<count type="int" />
+		<mode type="enum" choices="fast, slow" />
+		<unused />
+	</args>
+	<nodes>
+		<exec cmd="run" args="${count} ${mode}" />
+	</nodes>
+</graph>`, la: setArgs(map[string]string{"count": "x", "mode": "quick"}), want: []string{
+			`3: Arg "count" must be a int, not "x"`,
+			`4: Arg "mode" must be one of fast, slow, not "quick"`,
+			`5: warning: Arg "unused" is never used`,
+		}},
+	}
+	dir := t.TempDir()
+	for _, c := range cases {
+		got := problemList(Validate(writeGraph(t, dir, "g.xml", c.graph), c.la))
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: problems = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
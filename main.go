@@ -1,23 +1,43 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/hackborn/ghost/graph"
+	"io/ioutil"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 )
 
 func main() {
-	g, err := loadGraph()
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validate())
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export-graph" {
+		os.Exit(exportGraph())
+	}
+	if len(os.Args) > 1 && os.Args[1] == "graph" {
+		os.Exit(renderGraph())
+	}
+	if len(os.Args) > 1 && os.Args[1] == "list" {
+		os.Exit(list())
+	}
+	if len(os.Args) > 2 && wantsHelp(os.Args[2:]) {
+		os.Exit(help(os.Args[1]))
+	}
+
+	r, err := loadGraph()
 	if err != nil {
-		fmt.Println("Error loading graph:", err)
-		return
+		fmt.Println("Error loading graph:")
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	if g == nil {
+	if r == nil {
 		fmt.Println("Unknown error loading graph:")
-		return
+		os.Exit(1)
 	}
 
 	done := make(chan bool)
@@ -29,33 +49,236 @@ func main() {
 		done <- true
 	}()
 
-	g.Start()
+	if err := r.Start(); err != nil {
+		fmt.Println("Error starting graph:", err)
+	}
 	<-done
-	g.Stop()
+	r.Stop()
 }
 
-func loadGraph() (*graph.Graph, error) {
+// loadGraph answers the graph named on the command line, which
+// reloads whenever its files change.
+func loadGraph() (*graph.Reloader, error) {
 	// 1. Load graph based on the command line
 	// Provide defaults for now
 	graph_name := "test"
 	if len(os.Args) > 1 {
 		graph_name = os.Args[1]
 	}
-	return graph.Load(graph_name, loadCla)
+	var cla []string
+	if len(os.Args) > 2 {
+		cla = os.Args[2:]
+	}
+	return graph.NewReloader(graph_name, newLoadCla(cla))
+}
+
+// validate loads the graph named on the command line without running it,
+// printing every problem found. Answer the exit code.
+func validate() int {
+	if len(os.Args) <= 2 {
+		fmt.Println("Usage: ghost validate <graph> [args]")
+		return 2
+	}
+	filename, err := graph.Find(os.Args[2])
+	if err != nil {
+		fmt.Println("Error finding graph:", err)
+		return 1
+	}
+	// Arg values are only checked if some are supplied.
+	var la graph.LoadArgs
+	if len(os.Args) > 3 {
+		la = newLoadCla(os.Args[3:])
+	}
+	problems := graph.Validate(filename, la)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	errs := len(problems.Errors())
+	fmt.Printf("%v: %v error(s), %v warning(s)\n", filename, errs, len(problems)-errs)
+	if errs > 0 {
+		return 1
+	}
+	return 0
 }
 
-// Load graph arguments from the command line.
-func loadCla(args *graph.Args) {
+// exportGraph writes a graph compiled into the binary to disk, so it can be
+// customized. Answer the exit code.
+func exportGraph() int {
 	if len(os.Args) <= 2 {
-		return
+		fmt.Println("Usage: ghost export-graph <name> [file]")
+		fmt.Println("Built-in graphs:", strings.Join(graph.EmbeddedNames(), ", "))
+		return 2
+	}
+	name := os.Args[2]
+	filename := name + ".xml"
+	if len(os.Args) > 3 {
+		filename = os.Args[3]
+	}
+	err := graph.ExportEmbedded(name, filename)
+	if err != nil {
+		fmt.Println("Error exporting graph:", err)
+		return 1
+	}
+	fmt.Println("Exported", name, "to", filename)
+	return 0
+}
+
+// renderGraph prints the nodes and edges of the graph named on the command
+// line, in a format for diagram tools. Answer the exit code.
+func renderGraph() int {
+	if len(os.Args) <= 2 {
+		fmt.Println("Usage: ghost graph <graph> [--format=dot|mermaid] [args]")
+		return 2
+	}
+	filename, err := graph.Find(os.Args[2])
+	if err != nil {
+		fmt.Println("Error finding graph:", err)
+		return 1
 	}
-	fs := flag.NewFlagSet("fs", flag.ContinueOnError)
-	var values []*string
-	for _, a := range args.Arg {
-		values = append(values, fs.String(a.XMLName.Local, a.Value, a.Usage))
+	// The format can appear anywhere among the graph args.
+	format := graph.FormatDot
+	var cla []string
+	for i := 3; i < len(os.Args); i++ {
+		a := os.Args[i]
+		name := strings.TrimLeft(a, "-")
+		switch {
+		case strings.HasPrefix(name, "format="):
+			format = strings.TrimPrefix(name, "format=")
+		case name == "format" && a != name && i+1 < len(os.Args):
+			format = os.Args[i+1]
+			i++
+		default:
+			cla = append(cla, a)
+		}
 	}
-	fs.Parse(os.Args[2:])
-	for i := 0; i < len(values); i++ {
-		args.Arg[i].Value = *(values[i])
+	err = graph.Render(os.Stdout, filename, newLoadCla(cla), format)
+	if err != nil {
+		fmt.Println("Error rendering graph:")
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+// list prints every graph that can be run, with its description.
+// Answer the exit code.
+func list() int {
+	location := ""
+	for _, info := range graph.List() {
+		if info.Location != location {
+			location = info.Location
+			fmt.Println(location + ":")
+		}
+		line := "\t" + info.Name
+		if info.Description != "" {
+			line += " - " + info.Description
+		}
+		if info.Hidden {
+			line += " (hidden by an earlier graph with the same name)"
+		}
+		fmt.Println(line)
+	}
+	fmt.Println("Run \"ghost <graph> --help\" to see the args of a graph.")
+	return 0
+}
+
+// wantsHelp answers true if the command line args ask for help.
+func wantsHelp(cla []string) bool {
+	for _, a := range cla {
+		switch a {
+		case "-h", "-help", "--h", "--help":
+			return true
+		}
+	}
+	return false
+}
+
+// help prints the description and args of the named graph.
+// Answer the exit code.
+func help(name string) int {
+	filename, err := graph.Find(name)
+	if err != nil {
+		fmt.Println("Error finding graph:", err)
+		return 1
+	}
+	info := graph.ReadInfo(filename)
+	fmt.Println(info.Name + " (" + info.File + ")")
+	if info.Description != "" {
+		fmt.Println(info.Description)
+	}
+	fmt.Println("Usage: ghost " + name + " [args]")
+	if len(info.Args) <= 0 {
+		fmt.Println("This graph has no args.")
+		return 0
 	}
+	fmt.Println("Args:")
+	for _, a := range info.Args {
+		fmt.Println("\t-" + a.Name() + "=value")
+		if a.Usage != "" {
+			fmt.Println("\t\t" + a.Usage)
+		}
+		var details []string
+		if a.Required {
+			details = append(details, "required")
+		}
+		if a.Type != "" && a.Type != graph.ArgString {
+			details = append(details, "type "+a.Type)
+		}
+		if a.Type == graph.ArgEnum {
+			details = append(details, "one of "+a.Choices)
+		}
+		// Without any command line args, the value is the default.
+		if a.Value != "" {
+			details = append(details, "default \""+a.Value+"\"")
+		}
+		if len(details) > 0 {
+			fmt.Println("\t\t(" + strings.Join(details, ", ") + ")")
+		}
+	}
+	return 0
+}
+
+// Answer a function to load graph arguments from the command line args.
+func newLoadCla(cla []string) graph.LoadArgs {
+	return func(args *graph.Args) error {
+		if len(cla) <= 0 {
+			return nil
+		}
+		fs := flag.NewFlagSet("fs", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		for i := 0; i < len(args.Arg); i++ {
+			a := &args.Arg[i]
+			fs.Var(argFlag{a}, a.Name(), a.Usage)
+		}
+		if err := fs.Parse(cla); err != nil {
+			return err
+		}
+		// Flag parsing stops at the first value that isn't a flag.
+		if fs.NArg() > 0 {
+			return errors.New("unexpected \"" + fs.Arg(0) + "\", args are given as -name=value")
+		}
+		return nil
+	}
+}
+
+// argFlag sets the value of a graph arg from a command line flag.
+type argFlag struct {
+	arg *graph.Arg
+}
+
+func (f argFlag) String() string {
+	if f.arg == nil {
+		return ""
+	}
+	return f.arg.Value
+}
+
+func (f argFlag) Set(s string) error {
+	f.arg.Value = s
+	return nil
+}
+
+// IsBoolFlag allows bool args to be supplied without a value.
+func (f argFlag) IsBoolFlag() bool {
+	return f.arg != nil && f.arg.Type == graph.ArgBool
 }
@@ -0,0 +1,109 @@
+package node
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+)
+
+// Argt is a single command line arg, which is never split.
+type Argt struct {
+	Text string `xml:",chardata"`
+}
+
+// splitArgs splits a command line into args the way a POSIX shell does:
+// args are separated by whitespace, single quotes keep everything
+// inside them, double quotes keep everything but backslash escapes,
+// and a backslash escapes the next character. On Windows a backslash
+// only escapes a quote, so paths like C:\go don't need escaping.
+func splitArgs(s string) ([]string, error) {
+	escapes := runtime.GOOS != "windows"
+	// Inside double quotes, a backslash only escapes these.
+	quoteEscapes := "\"\\$`"
+	if !escapes {
+		quoteEscapes = "\""
+	}
+	var args []string
+	var cur strings.Builder
+	inArg := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote in args")
+			}
+			cur.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(quoteEscapes, s[i+1]) >= 0 {
+					i++
+				}
+				cur.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, errors.New("unterminated double quote in args")
+			}
+			inArg = true
+		case c == '\\' && (escapes || (i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\''))):
+			if i+1 >= len(s) {
+				return nil, errors.New("args end with a backslash")
+			}
+			i++
+			cur.WriteByte(s[i])
+			inArg = true
+		default:
+			cur.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// quoteArg answers the arg quoted so the shell treats it as a single word.
+func quoteArg(s string) string {
+	if runtime.GOOS == "windows" {
+		return "\"" + strings.Replace(s, "\"", "\\\"", -1) + "\""
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// commandLine answers the program and args to run. The args attribute is
+// split into args, followed by each <arg>. In shell mode the command is
+// given to the shell as-is, so it can use pipes, globs and redirects.
+func commandLine(cmd, args string, argList []Argt, shell bool) (string, []string, error) {
+	if shell {
+		line := cmd
+		if strings.TrimSpace(args) != "" {
+			line += " " + args
+		}
+		for _, a := range argList {
+			line += " " + quoteArg(a.Text)
+		}
+		if runtime.GOOS == "windows" {
+			return "cmd", []string{"/C", line}, nil
+		}
+		return "/bin/sh", []string{"-c", line}, nil
+	}
+	split, err := splitArgs(args)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, a := range argList {
+		split = append(split, a.Text)
+	}
+	return cmd, split, nil
+}
@@ -0,0 +1,78 @@
+package node
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	cases := []struct {
+		args string
+		want []string
+		err  bool
+		// True if the case relies on backslash escapes, which Windows doesn't have.
+		posix bool
+	}{
+		{args: "", want: nil},
+		{args: "   \t ", want: nil},
+		{args: "build -o app", want: []string{"build", "-o", "app"}},
+		{args: "  a\tb\n c  ", want: []string{"a", "b", "c"}},
+		{args: `-o "my app" ./...`, want: []string{"-o", "my app", "./..."}},
+		{args: `'it is' "here"`, want: []string{"it is", "here"}},
+		{args: `a"b c"d`, want: []string{"ab cd"}},
+		{args: `'a \" b'`, want: []string{`a \" b`}},
+		{args: `"say \"hi\""`, want: []string{`say "hi"`}},
+		{args: `"" ''`, want: []string{"", ""}},
+		{args: `"a \n b"`, want: []string{`a \n b`}, posix: true},
+		{args: `"\$HOME"`, want: []string{"$HOME"}, posix: true},
+		{args: `a\ b`, want: []string{"a b"}, posix: true},
+		{args: `\'x\'`, want: []string{"'x'"}},
+		{args: `C:\go\bin`, want: []string{`C:gobin`}, posix: true},
+		{args: `"unterminated`, err: true},
+		{args: `'unterminated`, err: true},
+		{args: `a\`, err: true, posix: true},
+	}
+	for _, c := range cases {
+		if c.posix && runtime.GOOS == "windows" {
+			continue
+		}
+		got, err := splitArgs(c.args)
+		if c.err {
+			if err == nil {
+				t.Errorf("splitArgs(%q) = %q, want an error", c.args, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitArgs(%q) error: %v", c.args, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", c.args, got, c.want)
+		}
+	}
+}
+
+func TestSplitArgsWindows(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("backslashes only escape quotes on Windows")
+	}
+	got, err := splitArgs(`C:\go\bin "a \"b\"" a\`)
+	want := []string{`C:\go\bin`, `a "b"`, `a\`}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("splitArgs() = %q %v, want %q", got, err, want)
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	name, args, err := commandLine("go", `build -o "my app"`, []Argt{{"a b"}}, false)
+	if err != nil || name != "go" || !reflect.DeepEqual(args, []string{"build", "-o", "my app", "a b"}) {
+		t.Errorf("commandLine() = %q %q %v", name, args, err)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	name, args, err = commandLine("ls", "*.go | wc -l", []Argt{{"it's"}}, true)
+	if err != nil || name != "/bin/sh" || !reflect.DeepEqual(args, []string{"-c", `ls *.go | wc -l 'it'\''s'`}) {
+		t.Errorf("commandLine() shell = %q %q %v", name, args, err)
+	}
+}
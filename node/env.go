@@ -0,0 +1,107 @@
+package node
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// EnvVar is an environment variable supplied to a process.
+type EnvVar struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// EnvUser is a node that runs processes, which inherit environment
+// variables from the graph.
+type EnvUser interface {
+	InheritEnv(env []EnvVar)
+}
+
+// validateEnv adds a problem for each variable that's missing a name.
+func validateEnv(env []EnvVar, p *problems) {
+	for _, v := range env {
+		if strings.TrimSpace(v.Name) == "" {
+			p.add(errors.New("env requires a name"))
+		}
+	}
+}
+
+// environ answers the environment for a process: ghost's own environment,
+// then the inherited variables, then the env file, then the node's
+// variables, with later values taking precedence. A relative env file
+// is found in the dir the process runs in.
+func environ(inherited []EnvVar, envFile, dir string, env []EnvVar) ([]string, error) {
+	if len(inherited) <= 0 && envFile == "" && len(env) <= 0 {
+		// Inherit everything from ghost, the same as exec.Cmd does.
+		return nil, nil
+	}
+	ans := os.Environ()
+	for _, v := range inherited {
+		ans = append(ans, v.Name+"="+v.Value)
+	}
+	if envFile != "" {
+		if !filepath.IsAbs(envFile) && dir != "" {
+			envFile = filepath.Join(dir, envFile)
+		}
+		vars, err := readEnvFile(envFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range vars {
+			ans = append(ans, v.Name+"="+v.Value)
+		}
+	}
+	for _, v := range env {
+		ans = append(ans, v.Name+"="+v.Value)
+	}
+	// exec.Cmd uses the last value of any duplicate.
+	return ans, nil
+}
+
+// readEnvFile answers the variables in a .env file. Each line is
+// NAME=value, optionally starting with "export". Values can be in
+// single quotes, taken as-is, or double quotes, which allow escapes
+// like \n. Blank lines and lines starting with # are skipped.
+func readEnvFile(filename string) ([]EnvVar, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var vars []EnvVar
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		s = strings.TrimSpace(strings.TrimPrefix(s, "export "))
+		i := strings.Index(s, "=")
+		if i <= 0 {
+			return nil, errors.New(filename + ":" + strconv.Itoa(line) + ": expected NAME=value")
+		}
+		name, value := strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+		switch {
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, errors.New(filename + ":" + strconv.Itoa(line) + ": " + err.Error())
+			}
+			value = unquoted
+		default:
+			// An unquoted value can end with a comment.
+			if c := strings.Index(value, " #"); c >= 0 {
+				value = strings.TrimSpace(value[:c])
+			}
+		}
+		vars = append(vars, EnvVar{name, value})
+	}
+	return vars, scanner.Err()
+}
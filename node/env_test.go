@@ -0,0 +1,54 @@
+package node
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadEnvFile(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		want []EnvVar
+		err  bool
+	}{
+		{name: "empty", text: "", want: nil},
+		{name: "comments", text: "# a comment\n\n   \n  # another\n", want: nil},
+		{name: "plain", text: "A=1\nB = two \n", want: []EnvVar{{"A", "1"}, {"B", "two"}}},
+		{name: "export", text: "export A=1\n  export   B=2\n", want: []EnvVar{{"A", "1"}, {"B", "2"}}},
+		{name: "empty value", text: "A=\n", want: []EnvVar{{"A", ""}}},
+		{name: "equals in value", text: "URL=postgres://h/db?a=b\n", want: []EnvVar{{"URL", "postgres://h/db?a=b"}}},
+		{name: "trailing comment", text: "A=1 # one\nB=x#y\n", want: []EnvVar{{"A", "1"}, {"B", "x#y"}}},
+		{name: "single quotes", text: `A='a \n # b'`, want: []EnvVar{{"A", `a \n # b`}}},
+		{name: "double quotes", text: `A="a\tb \"c\" # d"`, want: []EnvVar{{"A", "a\tb \"c\" # d"}}},
+		{name: "crlf", text: "A=1\r\nB=2\r\n", want: []EnvVar{{"A", "1"}, {"B", "2"}}},
+		{name: "later wins", text: "A=1\nA=2\n", want: []EnvVar{{"A", "1"}, {"A", "2"}}},
+		{name: "no equals", text: "A=1\nJUNK\n", err: true},
+		{name: "no name", text: "=1\n", err: true},
+		{name: "bad escape", text: `A="\q"`, err: true},
+	}
+	dir := t.TempDir()
+	for _, c := range cases {
+		filename := filepath.Join(dir, "test.env")
+		if err := os.WriteFile(filename, []byte(c.text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readEnvFile(filename)
+		if c.err {
+			if err == nil {
+				t.Errorf("%v: readEnvFile() = %v, want an error", c.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: readEnvFile() error: %v", c.name, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: readEnvFile() = %v, want %v", c.name, got, c.want)
+		}
+	}
+	if _, err := readEnvFile(filepath.Join(dir, "missing.env")); err == nil {
+		t.Errorf("readEnvFile() of a missing file, want an error")
+	}
+}
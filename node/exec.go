@@ -3,18 +3,24 @@ package node
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
-	"strings"
+	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 )
 
 // execfini is the result of a finished exec.Cmd.Run(). It bundles
-// the error value returned from Run() with a counter to identify the run.
+// the error value returned from Run() with a counter to identify the run,
+// and any output that was captured.
 type execfini struct {
-	runId int
-	err   error
+	runId  int
+	err    error
+	stdout string
+	stderr string
+	steps  []StepResult
 }
 
 // Exec runs a command. The command runs in a separate gofunc, spawned from
@@ -30,14 +36,94 @@ type Exec struct {
 	Interrupt bool   `xml:"interrupt,attr"`
 	Autorun   bool   `xml:"autorun,attr"`
 	Rerun     bool   `xml:"rerun,attr"`
-	LogList   []Logt `xml:"log"`
+	Merge     string `xml:"merge,attr"`
+	// The longest the cmd can run before it's stopped, i.e. "30s".
+	Timeout string `xml:"timeout,attr"`
+	// Args added after the args attribute, which are never split.
+	ArgList []Argt `xml:"arg"`
+	// When true, the cmd and args run as a single line in the shell.
+	Shell bool `xml:"shell,attr"`
+	// Cmds run in order instead of the cmd, see Step.
+	StepList []Step `xml:"step"`
+	// The signal sent to stop the cmd, and how long to wait before killing it.
+	StopSignal  string `xml:"stop_signal,attr"`
+	StopTimeout string `xml:"stop_timeout,attr"`
+	// How a rerun cmd restarts after it exits, see restartPolicy.
+	RestartDelay    string `xml:"restart_delay,attr"`
+	RestartMaxDelay string `xml:"restart_max_delay,attr"`
+	MaxRestarts     string `xml:"max_restarts,attr"`
+	RestartWindow   string `xml:"restart_window,attr"`
+	HealthyUptime   string `xml:"healthy_uptime,attr"`
+	// A file of NAME=value lines added to the environment of the process.
+	EnvFile string   `xml:"envfile,attr"`
+	Env     []EnvVar `xml:"env"`
+	LogList []Logt   `xml:"log"`
+	// A file the output is copied to, and when it's rotated.
+	LogFile     string `xml:"logfile,attr"`
+	LogMaxSize  string `xml:"log_max_size,attr"`
+	LogMaxFiles string `xml:"log_max_files,attr"`
+	// The number of lines of output included in the message sent downstream.
+	OutputLines string `xml:"output_lines,attr"`
+	// Checks that a running cmd is ready, see readyProbe.
+	ReadyPort    string `xml:"ready_port,attr"`
+	ReadyUrl     string `xml:"ready_url,attr"`
+	ReadyOutput  string `xml:"ready_output,attr"`
+	ReadyTimeout string `xml:"ready_timeout,attr"`
+	// Environment variables from the graph, overridden by EnvFile and Env.
+	inheritedEnv []EnvVar
+	// The log folder of the graph, and the default log file in it.
+	logDir  string
+	logName string
+	// The name in the graph, with the names of its includes.
+	fullName string
 	//	input     Channels
 	Channels // Output
+	// The on_success, on_failure and on_complete outputs.
+	outputs outputs
 	Cmds
 }
 
 func (e *Exec) IsValid() bool {
-	return len(e.Cmd) > 0
+	return len(e.Cmd) > 0 || len(e.StepList) > 0
+}
+
+// Validate answers every reason the exec can't run. Values that skip
+// answers true for aren't checked.
+func (e *Exec) Validate(skip func(string) bool) []error {
+	p := &problems{skip: skip}
+	if !e.IsValid() {
+		p.add(errors.New("requires a cmd"))
+	}
+	p.check(e.Merge, validateMerge(e.Merge))
+	validateEnv(e.Env, p)
+	if !e.Shell {
+		_, err := splitArgs(e.Args)
+		p.check(e.Args, err)
+	}
+	validateSteps(e, p)
+	_, err := parseStopSignal(e.StopSignal)
+	p.check(e.StopSignal, err)
+	_, err = parseStopTimeout(e.StopTimeout)
+	p.check(e.StopTimeout, err)
+	_, err = parseTimeout(e.Timeout)
+	p.check(e.Timeout, err)
+	newRestartPolicy(e, p)
+	if _, err = parseSize(e.LogMaxSize, defaultLogMaxSize); err != nil {
+		p.check(e.LogMaxSize, errors.New("log_max_size "+err.Error()))
+	}
+	if e.LogMaxFiles != "" {
+		if n, err := strconv.Atoi(e.LogMaxFiles); err != nil || n < 0 {
+			p.check(e.LogMaxFiles, errors.New("log_max_files \""+e.LogMaxFiles+"\" must be 0 or more"))
+		}
+	}
+	if e.OutputLines != "" {
+		if n, err := strconv.Atoi(e.OutputLines); err != nil || n < 0 {
+			p.check(e.OutputLines, errors.New("output_lines \""+e.OutputLines+"\" must be 0 or more"))
+		}
+	}
+	newReadyProbe(e, p)
+	e.Cmds.validate(p)
+	return p.errs
 }
 
 func (e *Exec) GetId() Id {
@@ -48,27 +134,124 @@ func (e *Exec) GetName() string {
 	return e.Name
 }
 
+// describe() answers the name of the exec for messages: its name in the
+// graph, or its cmd (the first step's, if it has steps).
+func (e *Exec) describe() string {
+	if e.fullName != "" {
+		return e.fullName
+	}
+	if e.Name != "" {
+		return e.Name
+	}
+	return e.steps()[0].Cmd
+}
+
 func (e *Exec) ApplyArgs(cs ChangeString) {
 	e.Cmd = cs.ChangeString(e.Cmd)
 	e.Args = cs.ChangeString(e.Args)
+	for i := 0; i < len(e.ArgList); i++ {
+		v := &e.ArgList[i]
+		v.Text = cs.ChangeString(v.Text)
+	}
 	e.Dir = cs.ChangeString(e.Dir)
+	e.Merge = cs.ChangeString(e.Merge)
+	e.Timeout = cs.ChangeString(e.Timeout)
+	e.StopSignal = cs.ChangeString(e.StopSignal)
+	e.StopTimeout = cs.ChangeString(e.StopTimeout)
+	e.RestartDelay = cs.ChangeString(e.RestartDelay)
+	e.RestartMaxDelay = cs.ChangeString(e.RestartMaxDelay)
+	e.MaxRestarts = cs.ChangeString(e.MaxRestarts)
+	e.RestartWindow = cs.ChangeString(e.RestartWindow)
+	e.HealthyUptime = cs.ChangeString(e.HealthyUptime)
+	e.LogMaxSize = cs.ChangeString(e.LogMaxSize)
+	e.LogMaxFiles = cs.ChangeString(e.LogMaxFiles)
+	e.OutputLines = cs.ChangeString(e.OutputLines)
+	e.ReadyPort = cs.ChangeString(e.ReadyPort)
+	e.ReadyUrl = cs.ChangeString(e.ReadyUrl)
+	e.ReadyOutput = cs.ChangeString(e.ReadyOutput)
+	e.ReadyTimeout = cs.ChangeString(e.ReadyTimeout)
+	for i := 0; i < len(e.StepList); i++ {
+		v := &e.StepList[i]
+		v.Cmd = cs.ChangeString(v.Cmd)
+		v.Args = cs.ChangeString(v.Args)
+		for j := 0; j < len(v.ArgList); j++ {
+			a := &v.ArgList[j]
+			a.Text = cs.ChangeString(a.Text)
+		}
+		v.Dir = cs.ChangeString(v.Dir)
+	}
+	e.EnvFile = cs.ChangeString(e.EnvFile)
+	e.LogFile = cs.ChangeString(e.LogFile)
+	for i := 0; i < len(e.Env); i++ {
+		v := &e.Env[i]
+		v.Value = cs.ChangeString(v.Value)
+	}
 	for i := 0; i < len(e.LogList); i++ {
 		v := &e.LogList[i]
 		v.Text = cs.ChangeString(v.Text)
 	}
 }
 
+// Output answers the on_success, on_failure or on_complete output.
+func (e *Exec) Output(name string) (Source, error) {
+	return e.outputs.get(e, name)
+}
+
+// sendResult sends the result of a run. The default output only sends
+// successful results.
+func (e *Exec) sendResult(msg Msg) {
+	if !MsgFailed(msg) {
+		e.SendMsg(msg)
+	}
+	e.outputs.send(msg)
+}
+
+// sendReady sends the message that a run is ready.
+func (e *Exec) sendReady(msg Msg) {
+	e.SendMsg(msg)
+	e.outputs.ready.SendMsg(msg)
+}
+
+// InheritEnv sets the environment variables from the graph.
+func (e *Exec) InheritEnv(env []EnvVar) {
+	e.inheritedEnv = env
+}
+
+// InheritLogDir sets the log folder of the graph, and the default log file.
+func (e *Exec) InheritLogDir(dir, name string) {
+	e.logDir, e.logName = dir, name
+}
+
+// InheritFullName sets the name in the graph, used in messages and results.
+func (e *Exec) InheritFullName(name string) {
+	e.fullName = name
+}
+
+// logFileName() answers the file the output is copied to, or nothing. With
+// a log folder, every exec logs to a file in it, by default.
+func (e *Exec) logFileName() string {
+	name := e.LogFile
+	if name == "" {
+		if e.logDir == "" || e.logName == "" {
+			return ""
+		}
+		name = e.logName
+	}
+	if e.logDir != "" && !filepath.IsAbs(name) {
+		name = filepath.Join(e.logDir, name)
+	}
+	return name
+}
+
 func (e *Exec) PrepareToStart(p Prepare, inputs []Source) (interface{}, error) {
 	// No inputs means this node is never hit, so ignore.
 	if len(inputs) <= 0 {
 		return nil, nil
 	}
 
-	// If we want multiple inputs, we'll need to expand the running
-	// code to handle merging.
-	if len(inputs) != 1 {
-		fmt.Println("node.Exec.Start() must have 1 input (for now)", len(inputs))
-		return nil, errors.New("node.Exec.Start does not support multiple inputs")
+	err := validateMerge(e.Merge)
+	if err != nil {
+		return nil, err
 	}
 
 	data := prepareDataExec{}
@@ -80,6 +263,7 @@ func (e *Exec) PrepareToStart(p Prepare, inputs []Source) (interface{}, error) {
 	if data.mainFiniChan == nil {
 		return nil, errors.New("node.Exec can't make fini channel")
 	}
+	data.readyChan = make(chan readyfini)
 	data.mergeChan = make(chan Msg)
 	if data.mergeChan == nil {
 		return nil, errors.New("node.Exec can't make merge channel")
@@ -99,15 +283,35 @@ func (e *Exec) PrepareToStart(p Prepare, inputs []Source) (interface{}, error) {
 		data.input.Add(i.NewChannel())
 	}
 
+	if name := e.logFileName(); name != "" {
+		// These were checked by Validate().
+		maxSize, _ := parseSize(e.LogMaxSize, defaultLogMaxSize)
+		maxFiles := defaultLogMaxFiles
+		if e.LogMaxFiles != "" {
+			maxFiles, _ = strconv.Atoi(e.LogMaxFiles)
+		}
+		data.log, err = openLogFile(name, maxSize, maxFiles)
+		if err != nil {
+			return nil, errors.New("node.Exec can't open log file: " + err.Error())
+		}
+	}
+
 	return data, nil
 }
 
+// ReleasePrepared closes the log file opened by PrepareToStart.
+func (e *Exec) ReleasePrepared(idata interface{}) {
+	if data, ok := idata.(prepareDataExec); ok && data.log != nil {
+		data.log.Close()
+	}
+}
+
 func (e *Exec) Start(s Start, idata interface{}) error {
 	data, ok := idata.(prepareDataExec)
 	if !ok {
 		return errors.New("node.Exec no prepareData")
 	}
-	if len(data.input.Out) != 1 {
+	if len(data.input.Out) <= 0 {
 		return errors.New("node.Exec no inputs")
 	}
 	//	fmt.Println("Start exec", e, "ins", len(data.input.Out), "outs", len(e.Out))
@@ -130,13 +334,23 @@ func (e *Exec) Start(s Start, idata interface{}) error {
 	waiter := s.GetDoneWaiter()
 	waiter.Add(1)
 	go func(owner Owner, done <-chan struct{}, waiter *sync.WaitGroup, data prepareDataExec, inputChan chan Msg) {
-		proc := process{e.Cmd, e.Args, e.Dir, 0, nil}
-		handler := newHandleFromMain(owner, &proc, data.mainFiniChan, e)
+		// These were checked by Validate().
+		stopSignal, _ := parseStopSignal(e.StopSignal)
+		stopTimeout, _ := parseStopTimeout(e.StopTimeout)
+		runTimeout, _ := parseTimeout(e.Timeout)
+		outputLines, _ := strconv.Atoi(e.OutputLines)
+		proc := process{name: e.describe(), steps: e.steps(), reportSteps: len(e.StepList) > 0, shell: e.Shell,
+			inheritedEnv: e.inheritedEnv, envFile: e.EnvFile, env: e.Env, stopSignal: stopSignal, stopTimeout: stopTimeout, runTimeout: runTimeout,
+			log: data.log, outputLines: outputLines, readyChan: data.readyChan}
+		proc.ready = newReadyProbe(e, nil)
+		policy := newRestartPolicy(e, nil)
+		handler := newHandleFromMain(owner, &proc, data.mainFiniChan, e, policy)
 
 		defer waiter.Done()
 		defer debug("end exec main %v", e.Id)
 		defer proc.close()
 		defer e.CloseChannels()
+		defer e.outputs.close()
 
 		debug("start exec main %v name=%v", e.Id, e.Name)
 
@@ -156,6 +370,10 @@ func (e *Exec) Start(s Start, idata interface{}) error {
 				if more {
 					handler.handleMsg(&msg, fromInput)
 				}
+			case <-handler.policy.wait():
+				handler.handleRestart()
+			case ready := <-data.readyChan:
+				handler.handleReady(ready)
 			case fini, more := <-data.mainFiniChan:
 				if more {
 					handler.handleFini(fini, fromStatus)
@@ -181,6 +399,8 @@ func (e *Exec) startMerge(s Start, data prepareDataExec) error {
 
 	done := s.GetDoneChannel()
 	waiter := s.GetDoneWaiter()
+	inputChan := startFanIn(s, data.input)
+	merger := newMerger(e.Merge, len(data.input.Out))
 
 	waiter.Add(1)
 	go func(done <-chan struct{}, waiter *sync.WaitGroup, timer *time.Timer, data prepareDataExec) {
@@ -189,7 +409,6 @@ func (e *Exec) startMerge(s Start, data prepareDataExec) error {
 		defer close(data.mergeChan)
 
 		debug("start exec merge %v", e.Id)
-		var last Msg
 
 		// An extremely simple handling of the timer right now --
 		// it will get retriggered as long as I receive new events,
@@ -200,13 +419,12 @@ func (e *Exec) startMerge(s Start, data prepareDataExec) error {
 			select {
 			case <-done:
 				return
-			case msg, more := <-data.input.Out[0]:
-				if more {
-					last = msg
+			case msg := <-inputChan:
+				if merger.add(msg) {
 					timer.Reset(100 * time.Millisecond)
 				}
 			case <-timer.C:
-				data.mergeChan <- last
+				data.mergeChan <- merger.fire()
 			}
 		}
 	}(done, waiter, timer, data)
@@ -258,31 +476,103 @@ type prepareDataExec struct {
 	// The cmd runs in a separate gofunc. This channel communicates back to the main func,
 	// returning the result of the run (specifically, exec.Cmd.Run()).
 	mainFiniChan chan execfini
+	// The log file, if the output is copied to one.
+	log *logFile
+	// Reports when a run is ready, if the exec has readiness checks.
+	readyChan chan readyfini
 }
 
 // process manages an exec cmd.
 type process struct {
-	cmdStr string
-	argStr string
-	dirStr string
-	// The ID for the current run
+	// The name of the exec, for messages.
+	name string
+	// The cmds of each run, and whether to report each one as it ends.
+	steps       []Step
+	reportSteps bool
+	shell       bool
+	// The environment, see environ().
+	inheritedEnv []EnvVar
+	envFile      string
+	env          []EnvVar
+	// How the cmd is stopped, see stop().
+	stopSignal  string
+	stopTimeout time.Duration
+	// The longest a run can take, or 0 for no limit.
+	runTimeout time.Duration
+	// The output is copied to the log, if there is one.
+	log *logFile
+	// The number of lines of output captured from each run, or 0.
+	outputLines int
+	// The output captured from the current run.
+	stdoutTail *outputTail
+	stderrTail *outputTail
+	// Checks when each run is ready, and reports it on the channel.
+	ready     *readyProbe
+	readyChan chan readyfini
+	// Watches the output of the current run for the ready line.
+	matcher *lineMatcher
+	// The ID for the current run.
 	runId int
-	cmd   *exec.Cmd
+	// When the current run started.
+	started time.Time
+	// Controls the current run.
+	ctl *runControl
+}
+
+// runControl lets the main func stop the run of a cmd, which happens
+// in its own func.
+type runControl struct {
+	// Closed to stop the run.
+	stop     chan struct{}
+	stopping bool
+	// Closed when no one is waiting for the result of the run.
+	abandon chan struct{}
+	// Closed when the run has ended.
+	done chan struct{}
+}
+
+// stopped() answers true if the run has been stopped. Unlike stopping,
+// it's safe to call from the func running the cmd.
+func (c *runControl) stopped() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
 }
 
 func (p *process) isRunning() bool {
-	return p.cmd != nil
+	return p.ctl != nil
+}
+
+// isStopping() answers true if the running cmd has been asked to stop.
+func (p *process) isStopping() bool {
+	return p.ctl != nil && p.ctl.stopping
 }
 
+// stop() asks the cmd to stop: its process group is sent the stop signal,
+// and killed if it's still running after the stop timeout. The cmd is
+// running until its result is received through finished().
 func (p *process) stop() {
-	if p.cmd != nil && p.cmd.Process != nil {
-		p.cmd.Process.Kill()
+	if p.ctl != nil && !p.ctl.stopping {
+		p.ctl.stopping = true
+		close(p.ctl.stop)
 	}
-	p.cmd = nil
 }
 
+// close() stops the cmd and waits for it to end.
 func (p *process) close() {
-	p.stop()
+	if ctl := p.ctl; ctl != nil {
+		p.stop()
+		close(ctl.abandon)
+		<-ctl.done
+	}
+	p.ctl = nil
+	if p.log != nil {
+		p.log.Close()
+		p.log = nil
+	}
 }
 
 // finished() is set when the cmd status channel has reported completion.
@@ -293,66 +583,209 @@ func (p *process) finished(fini execfini) bool {
 		debug("exec.process.finished() discard previous run (current=%v, received=%v)", p.runId, fini.runId)
 		return false
 	}
-	p.cmd = nil
+	p.ctl = nil
 	return true
 }
 
 func (p *process) run(c chan execfini, logs []Logt) {
 	// XXX We're just ignoring if the current one is running.
 	// Should this try and kill it?
-	p.cmd = p.newCmd()
-	if p.cmd == nil {
-		return
+	p.stdoutTail, p.stderrTail = nil, nil
+	if p.outputLines > 0 {
+		p.stdoutTail, p.stderrTail = newOutputTail(p.outputLines), newOutputTail(p.outputLines)
+	}
+	p.matcher = nil
+	if p.ready != nil && p.ready.output != nil {
+		p.matcher = newLineMatcher(p.ready.output)
+	}
+	// A cmd that can't be made fails the run, the same as one that can't start.
+	var cmds []*exec.Cmd
+	var err error
+	for _, step := range p.steps {
+		var cmd *exec.Cmd
+		cmd, err = p.newCmd(step)
+		if err != nil {
+			fmt.Println("exec error:", err)
+			break
+		}
+		cmds = append(cmds, cmd)
 	}
 	p.runId++
-	go func(runId int, proc *exec.Cmd, c chan execfini, logs []Logt) {
+	p.started = time.Now()
+	p.ctl = &runControl{stop: make(chan struct{}), abandon: make(chan struct{}), done: make(chan struct{})}
+	var out io.Writer = os.Stdout
+	if p.log != nil {
+		p.log.separate(p.runId)
+		out = io.MultiWriter(os.Stdout, p.log)
+	}
+	go func(runId int, cmds []*exec.Cmd, err error, c chan execfini, logs []Logt, out io.Writer, stdout, stderr *outputTail, ctl *runControl) {
+		defer close(ctl.done)
 		for _, v := range logs {
-			fmt.Println(v.Text)
+			fmt.Fprintln(out, v.Text)
+		}
+		var steps []StepResult
+		if err == nil {
+			steps, err = p.runSteps(cmds, ctl)
+		}
+		select {
+		case c <- execfini{runId, err, stdout.String(), stderr.String(), steps}:
+		case <-ctl.abandon:
 		}
-		c <- execfini{runId, proc.Run()}
-	}(p.runId, p.cmd, c, logs)
+	}(p.runId, cmds, err, c, logs, out, p.stdoutTail, p.stderrTail, p.ctl)
+	if p.ready != nil && err == nil {
+		p.waitReady()
+	}
 }
 
-func (p *process) newCmd() *exec.Cmd {
-	cmd := exec.Command(p.cmdStr)
-	if cmd == nil {
-		fmt.Println("exec error: Couldn't create exec.Command")
-		return nil
+// waitReady() waits in its own func for the current run to be ready.
+func (p *process) waitReady() {
+	var matched <-chan struct{}
+	if p.matcher != nil {
+		matched = p.matcher.matched
 	}
-	cmd.Dir = p.dirStr
-	if len(p.argStr) > 0 {
-		for _, v := range formatArgs(p.argStr) {
-			cmd.Args = append(cmd.Args, v)
+	go func(runId int, started time.Time, probe *readyProbe, ctl *runControl, matched <-chan struct{}, c chan readyfini) {
+		err := probe.wait(ctl, matched)
+		if err == errNotWaiting {
+			return
+		}
+		select {
+		case c <- readyfini{runId, err, time.Since(started)}:
+		case <-ctl.abandon:
 		}
+	}(p.runId, p.started, p.ready, p.ctl, matched, p.readyChan)
+}
+
+// runSteps runs the cmd of each step in order, until one fails or the
+// run is stopped. The run timeout applies to all of them together.
+// Answer the result of each step, and the error that ended the run, if any.
+func (p *process) runSteps(cmds []*exec.Cmd, ctl *runControl) ([]StepResult, error) {
+	var timedOut <-chan time.Time
+	if p.runTimeout > 0 {
+		timer := time.NewTimer(p.runTimeout)
+		defer timer.Stop()
+		timedOut = timer.C
 	}
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd
-}
-
-// formatArgs is I hope a hack: The user specifies args
-// as a single string, with spaces. However the cmd.Run() chokes
-// on this; it needs each arg as a separate entry in a slice. So
-// take a quick stab at something that should get us there: The
-// source list is parsed, separating by space, unless we're in quotes
-func formatArgs(_in string) []string {
-	in := strings.TrimSpace(_in)
-	out := []string{}
-	in_dquote := false
-	start := 0
-	for i := 0; i < len(in); i++ {
-		c := string(in[i])
-		if c == " " && !in_dquote {
-			if i-start > 0 {
-				out = append(out, in[start:i])
+	var err error
+	results := make([]StepResult, len(cmds))
+	for i, proc := range cmds {
+		step := p.steps[i]
+		results[i] = StepResult{Cmd: step.describe(), Skipped: true}
+		if err == nil && ctl.stopped() {
+			err = errors.New("stopped")
+		}
+		if err != nil {
+			continue
+		}
+		started := time.Now()
+		serr := proc.Start()
+		if serr == nil {
+			serr = waitOrStop(proc, ctl, p.stopSignal, p.stopTimeout, timedOut, p.runTimeout)
+		}
+		results[i] = StepResult{Cmd: step.describe(), Err: serr, ExitCode: exitCode(serr), Duration: time.Since(started)}
+		if p.reportSteps {
+			status := "done"
+			if serr != nil {
+				status = "failed (" + serr.Error() + ")"
 			}
-			start = i + 1
-		} else if c == "\"" {
-			in_dquote = !in_dquote
+			fmt.Printf("exec %v: step %v/%v %v %v in %v\n", p.name, i+1, len(cmds), step.describe(), status, results[i].Duration.Round(time.Millisecond))
+		}
+		if serr == nil {
+			continue
+		}
+		if _, ok := serr.(timeoutError); ok || ctl.stopped() || !step.ContinueOnError {
+			err = serr
 		}
 	}
-	if len(in) > start {
-		out = append(out, in[start:len(in)])
+	return results, err
+}
+
+// waitOrStop waits for the started cmd to exit. If the run is stopped
+// first, or runs longer than the run timeout, the cmd is stopped with
+// stopGroup(). Answer the result of the cmd, or a timeoutError.
+func waitOrStop(proc *exec.Cmd, ctl *runControl, signal string, timeout time.Duration, timedOut <-chan time.Time, runTimeout time.Duration) error {
+	exited := make(chan error, 1)
+	go func() {
+		exited <- proc.Wait()
+	}()
+	select {
+	case err := <-exited:
+		return err
+	case <-ctl.stop:
+		return stopGroup(proc, exited, signal, timeout)
+	case <-timedOut:
+		debug("exec pid %v timed out after %v", proc.Process.Pid, runTimeout)
+		stopGroup(proc, exited, signal, timeout)
+		return timeoutError{runTimeout}
+	}
+}
+
+// stopGroup sends the signal to the cmd's process group, and kills the
+// group if it hasn't exited by the timeout. Answer the result of the cmd.
+func stopGroup(proc *exec.Cmd, exited chan error, signal string, timeout time.Duration) error {
+	debug("exec stop pid %v with %v", proc.Process.Pid, signal)
+	deadline := time.After(timeout)
+	if signalGroup(proc.Process, signal) != nil {
+		killGroup(proc.Process)
+	}
+	var err error
+	select {
+	case err = <-exited:
+	case <-deadline:
+		debug("exec kill pid %v after %v", proc.Process.Pid, timeout)
+		killGroup(proc.Process)
+		return <-exited
+	}
+	// The cmd has exited, but processes it started might still be running.
+	for groupExists(proc.Process) {
+		select {
+		case <-deadline:
+			debug("exec kill group %v after %v", proc.Process.Pid, timeout)
+			killGroup(proc.Process)
+			return err
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return err
+}
+
+func (p *process) newCmd(step Step) (*exec.Cmd, error) {
+	name, args, err := commandLine(step.Cmd, step.Args, step.ArgList, p.shell)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(name, args...)
+	if cmd == nil {
+		return nil, errors.New("couldn't create exec.Command")
+	}
+	cmd.Dir = step.Dir
+	startGroup(cmd)
+	// The env file is read on every run, so edits apply the next time the cmd runs.
+	env, err := environ(p.inheritedEnv, p.envFile, step.Dir, p.env)
+	if err != nil {
+		return nil, errors.New("couldn't read envfile: " + err.Error())
+	}
+	cmd.Env = env
+	stdout := []io.Writer{os.Stdout}
+	stderr := []io.Writer{os.Stderr}
+	if p.log != nil {
+		stdout = append(stdout, p.log)
+		stderr = append(stderr, p.log)
+	}
+	if p.stdoutTail != nil {
+		stdout = append(stdout, p.stdoutTail)
+		stderr = append(stderr, p.stderrTail)
+	}
+	if p.matcher != nil {
+		stdout = append(stdout, p.matcher)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if len(stdout) > 1 {
+		cmd.Stdout = io.MultiWriter(stdout...)
+		cmd.Stderr = io.MultiWriter(stderr...)
+		// The output is copied through pipes, which processes started by
+		// the cmd can hold open after it exits. Don't wait for them forever.
+		cmd.WaitDelay = time.Second
 	}
-	return out
+	return cmd, nil
 }
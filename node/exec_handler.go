@@ -1,5 +1,10 @@
 package node
 
+import (
+	"fmt"
+	"time"
+)
+
 type fromChan int
 
 const (
@@ -31,10 +36,12 @@ type handleFromMain struct {
 	stop_msg  Msg
 	// Solely so I can send a msg down the pipe. Should be a cleaner way.
 	ex *Exec
+	// When to rerun the cmd after it exits.
+	policy *restartPolicy
 }
 
-func newHandleFromMain(owner Owner, proc *process, status chan execfini, ex *Exec) *handleFromMain {
-	return &handleFromMain{owner, proc, status, false, false, Msg{}, ex}
+func newHandleFromMain(owner Owner, proc *process, status chan execfini, ex *Exec, policy *restartPolicy) *handleFromMain {
+	return &handleFromMain{owner, proc, status, false, false, Msg{}, ex, policy}
 }
 
 func (h *handleFromMain) close() {
@@ -56,6 +63,8 @@ func (h *handleFromMain) handleFromControl(msg *Msg) {
 	cmd := CmdFromMsg(*msg)
 	if cmd != nil {
 		if cmd.Method == cmdStop {
+			// A stopped cmd stays stopped until it's triggered again.
+			h.policy.cancel()
 			if !h.proc.isRunning() {
 				reply := Cmd{Method: cmdStopReply, TargetId: msg.SenderId}
 				rmsg := reply.AsMsg()
@@ -72,10 +81,18 @@ func (h *handleFromMain) handleFromControl(msg *Msg) {
 
 func (h *handleFromMain) handleFromInput(msg *Msg) {
 	debug("exec msg %v", msg)
+	// Being triggered is a fresh start, even if the cmd had given up restarting.
+	h.policy.reset()
 	if !h.proc.isRunning() {
 		h.proc.run(h.status, h.ex.LogList)
-	} else {
-		h.needs_run = true
+		return
+	}
+	// Run again once the current run ends. With interrupt, end it now
+	// instead of letting a stale run finish; it's rerun when it's stopped.
+	h.needs_run = true
+	if h.ex.Interrupt && !h.in_stop && !h.proc.isStopping() {
+		fmt.Printf("exec %v: interrupted by new input, restarting\n", h.ex.describe())
+		h.proc.stop()
 	}
 }
 
@@ -86,10 +103,19 @@ func (h *handleFromMain) handleFini(fini execfini, from fromChan) {
 }
 
 func (h *handleFromMain) handleFromStatus(fini execfini) {
+	uptime := time.Since(h.proc.started)
 	if !h.proc.finished(fini) {
 		return
 	}
+	// A timeout is reported even if a stop or a rerun was waiting for
+	// the run to end, since it's a failure no matter what's next.
+	_, timedOut := fini.err.(timeoutError)
+	if timedOut {
+		fmt.Printf("exec %v: %v, stopped\n", h.ex.describe(), fini.err)
+		h.sendResult(fini, uptime)
+	}
 	needs_run := false
+	rerun := false
 	if h.in_stop {
 		h.in_stop = false
 		reply := Cmd{Method: cmdStopReply, TargetId: h.stop_msg.SenderId}
@@ -99,21 +125,66 @@ func (h *handleFromMain) handleFromStatus(fini execfini) {
 	} else if h.needs_run {
 		h.needs_run = false
 		needs_run = true
-	} else if fini.err == nil {
-		// Process completed successfully
-		h.ex.SendMsg(Msg{})
-		if h.ex.Rerun {
-			needs_run = true
-		}
 	} else {
-		// Some sort of error -- possibly a crash.
-		if h.ex.Rerun {
-			needs_run = true
+		// Report the result either way; a failure could be a crash.
+		if !timedOut {
+			h.sendResult(fini, uptime)
 		}
+		rerun = h.ex.Rerun
 	}
 
 	if needs_run {
 		h.proc.run(h.status, h.ex.LogList)
+	} else if rerun {
+		h.restart(uptime, fini.err)
+	}
+}
+
+// sendResult() sends the result of the run to the next nodes.
+func (h *handleFromMain) sendResult(fini execfini, uptime time.Duration) {
+	result := newResult(h.ex.describe(), fini.runId, fini.err, uptime)
+	result.Stdout, result.Stderr, result.Steps = fini.stdout, fini.stderr, fini.steps
+	h.ex.sendResult(result.AsMsg())
+}
+
+// restart() reruns the cmd after it exited on its own, waiting first
+// if it's restarting too quickly.
+func (h *handleFromMain) restart(uptime time.Duration, err error) {
+	delay, ok := h.policy.next(time.Now(), uptime)
+	if !ok {
+		fmt.Printf("exec %v: gave up after %v restarts within %v, waiting to be triggered again\n", h.ex.describe(), h.policy.maxRestarts, h.policy.window)
+		return
+	}
+	if delay <= 0 {
+		h.proc.run(h.status, h.ex.LogList)
+		return
+	}
+	reason := "exited"
+	if err != nil {
+		reason = "failed (" + err.Error() + ")"
+	}
+	fmt.Printf("exec %v: %v after %v, restarting in %v\n", h.ex.describe(), reason, uptime.Round(time.Millisecond), delay)
+	h.policy.schedule(delay)
+}
+
+// handleReady() reports that the current run is ready, or isn't in time.
+func (h *handleFromMain) handleReady(ready readyfini) {
+	if ready.runId != h.proc.runId || !h.proc.isRunning() {
+		return
+	}
+	if ready.err != nil {
+		fmt.Printf("exec %v: %v\n", h.ex.describe(), ready.err)
+		return
+	}
+	fmt.Printf("exec %v: ready after %v\n", h.ex.describe(), ready.elapsed.Round(time.Millisecond))
+	h.ex.sendReady(readyMsg(h.ex.describe(), ready.runId, ready.elapsed))
+}
+
+// handleRestart() runs the cmd when the wait to restart it is over.
+func (h *handleFromMain) handleRestart() {
+	h.policy.fired()
+	if !h.proc.isRunning() {
+		h.proc.run(h.status, h.ex.LogList)
 	}
 }
 
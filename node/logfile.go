@@ -0,0 +1,155 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The size a log file can grow to before it's rotated.
+	defaultLogMaxSize = 10 * 1024 * 1024
+	// The number of rotated log files kept.
+	defaultLogMaxFiles = 5
+)
+
+// LogUser is a node that writes a log file, which goes in the log
+// folder of the graph. The graph supplies a name for the file that's
+// unique within the graph.
+type LogUser interface {
+	InheritLogDir(dir, name string)
+}
+
+// parseSize answers the number of bytes in a size like "10MB", "512KB"
+// or "1000". An empty size is the default.
+func parseSize(s string, def int64) (int64, error) {
+	if s == "" {
+		return def, nil
+	}
+	n := strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(n, u.suffix) {
+			n, mult = strings.TrimSpace(strings.TrimSuffix(n, u.suffix)), u.mult
+			break
+		}
+	}
+	v, err := strconv.ParseInt(n, 10, 64)
+	if err != nil || v < 0 {
+		return 0, errors.New("\"" + s + "\" must be a number of bytes, optionally followed by KB, MB or GB")
+	}
+	return v * mult, nil
+}
+
+// logFile is a log file that rotates when it reaches the max size:
+// the file is renamed with the suffix ".1", any previous ".1" becomes
+// ".2" and so on, keeping at most the max number of files.
+type logFile struct {
+	mutex    sync.Mutex
+	name     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+	// True once an error has been reported.
+	failed bool
+}
+
+// openLogFile opens the log file for appending, creating its folder if necessary.
+func openLogFile(name string, maxSize int64, maxFiles int) (*logFile, error) {
+	l := &logFile{name: name, maxSize: maxSize, maxFiles: maxFiles}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *logFile) open() error {
+	f, err := os.OpenFile(l.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size = f, info.Size()
+	return nil
+}
+
+// Write copies the output to the file. It never fails, so a log file
+// can't break the cmd whose output it copies: the first error is
+// reported, and output that can't be written is dropped.
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return len(p), nil
+	}
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(p)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			l.fail(err)
+			return len(p), nil
+		}
+	}
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	if err != nil {
+		l.fail(err)
+	}
+	return len(p), nil
+}
+
+// fail reports the error, if it's the first one.
+func (l *logFile) fail(err error) {
+	if !l.failed {
+		l.failed = true
+		fmt.Printf("log file %v: %v, output isn't being logged\n", l.name, err)
+	}
+}
+
+// rotate closes the file, shifts the previous files and starts a new one.
+func (l *logFile) rotate() error {
+	l.file.Close()
+	l.file = nil
+	if l.maxFiles <= 0 {
+		os.Remove(l.name)
+	} else {
+		os.Remove(l.name + "." + strconv.Itoa(l.maxFiles))
+		for i := l.maxFiles - 1; i > 0; i-- {
+			os.Rename(l.name+"."+strconv.Itoa(i), l.name+"."+strconv.Itoa(i+1))
+		}
+		if err := os.Rename(l.name, l.name+".1"); err != nil {
+			return err
+		}
+	}
+	return l.open()
+}
+
+// separate writes a line marking the start of a run.
+func (l *logFile) separate(runId int) {
+	fmt.Fprintf(l, "==== run %v started %v ====\n", runId, time.Now().Format("2006-01-02 15:04:05"))
+}
+
+func (l *logFile) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,76 @@
+package node
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	cases := []struct {
+		size string
+		want int64
+		err  bool
+	}{
+		{size: "", want: 42},
+		{size: "0", want: 0},
+		{size: "1000", want: 1000},
+		{size: "100B", want: 100},
+		{size: "512KB", want: 512 << 10},
+		{size: "10MB", want: 10 << 20},
+		{size: "2GB", want: 2 << 30},
+		{size: "10mb", want: 10 << 20},
+		{size: " 10 MB ", want: 10 << 20},
+		{size: "MB", err: true},
+		{size: "ten", err: true},
+		{size: "1.5MB", err: true},
+		{size: "-1", err: true},
+		{size: "10TB", err: true},
+	}
+	for _, c := range cases {
+		got, err := parseSize(c.size, 42)
+		if c.err {
+			if err == nil {
+				t.Errorf("parseSize(%q) = %v, want an error", c.size, got)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("parseSize(%q) = %v %v, want %v", c.size, got, err, c.want)
+		}
+	}
+}
+
+func TestLogFileWrite(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	l, err := openLogFile(filepath.Join(dir, "test.log"), 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writes := []struct {
+		text  string
+		after func()
+	}{
+		{text: "12345678"},
+		// Rotates.
+		{text: "12345678", after: func() {
+			if runtime.GOOS != "windows" {
+				os.RemoveAll(dir)
+			}
+		}},
+		// Can't rotate, since the folder is gone.
+		{text: "12345678", after: func() { l.Close() }},
+		// Closed.
+		{text: "12345678"},
+	}
+	for i, w := range writes {
+		n, err := l.Write([]byte(w.text))
+		if n != len(w.text) || err != nil {
+			t.Errorf("write %v: Write() = %v %v, want %v nil", i+1, n, err, len(w.text))
+		}
+		if w.after != nil {
+			w.after()
+		}
+	}
+}
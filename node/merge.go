@@ -0,0 +1,119 @@
+package node
+
+import (
+	"errors"
+	"sync"
+)
+
+// Merge policies determine how a node with multiple inputs fires.
+const (
+	// Fire whenever any input sends a message.
+	MergeAny = "any"
+	// Wait until every input has sent a message, then fire once.
+	MergeAll = "all"
+	// Once every input has sent a message, fire whenever any input sends
+	// another, combining the most recent message from each input.
+	MergeLatest = "latest"
+)
+
+// inputMsg is a message tagged with the index of the input it arrived on.
+type inputMsg struct {
+	index int
+	msg   Msg
+}
+
+// validateMerge() answers an error if the merge policy is unknown.
+func validateMerge(policy string) error {
+	switch policy {
+	case "", MergeAny, MergeAll, MergeLatest:
+		return nil
+	}
+	return errors.New("unknown merge policy \"" + policy + "\" (must be any, all or latest)")
+}
+
+// startFanIn() forwards every input channel into a single channel,
+// tagging each message with the index of its input.
+func startFanIn(s Start, input Channels) chan inputMsg {
+	c := make(chan inputMsg)
+	done := s.GetDoneChannel()
+	waiter := s.GetDoneWaiter()
+	for i, in := range input.Out {
+		waiter.Add(1)
+		go func(done <-chan struct{}, waiter *sync.WaitGroup, index int, in chan Msg) {
+			defer waiter.Done()
+			for {
+				select {
+				case <-done:
+					return
+				case msg, more := <-in:
+					if !more {
+						return
+					}
+					select {
+					case <-done:
+						return
+					case c <- inputMsg{index, msg}:
+					}
+				}
+			}
+		}(done, waiter, i, in)
+	}
+	return c
+}
+
+// merger applies a merge policy to the messages arriving from a node's inputs.
+type merger struct {
+	policy string
+	last   Msg
+	latest []Msg
+	has    []bool
+}
+
+func newMerger(policy string, size int) *merger {
+	if policy == "" {
+		policy = MergeAny
+	}
+	return &merger{policy, Msg{}, make([]Msg, size), make([]bool, size)}
+}
+
+// add() stores a message, answering true if the node is ready to fire.
+func (m *merger) add(in inputMsg) bool {
+	m.last = in.msg
+	if in.index < 0 || in.index >= len(m.latest) {
+		return false
+	}
+	m.latest[in.index] = in.msg
+	m.has[in.index] = true
+	if m.policy == MergeAny {
+		return true
+	}
+	for _, v := range m.has {
+		if !v {
+			return false
+		}
+	}
+	return true
+}
+
+// fire() answers the message to send downstream, and prepares for the next round.
+func (m *merger) fire() Msg {
+	if m.policy == MergeAny {
+		return m.last
+	}
+	// Combine the values of each input, with later inputs taking precedence.
+	var msg Msg
+	for _, v := range m.latest {
+		for k, value := range v.Values {
+			if msg.Values == nil {
+				msg.Values = make(map[string]interface{})
+			}
+			msg.Values[k] = value
+		}
+	}
+	if m.policy == MergeAll {
+		for i := range m.has {
+			m.has[i] = false
+		}
+	}
+	return msg
+}
@@ -0,0 +1,77 @@
+package node
+
+import (
+	"errors"
+)
+
+// The named outputs of an exec. The default output sends the result
+// of every successful run, the same as OutputSuccess, and the ready
+// message, the same as OutputReady.
+const (
+	// Send the result of a successful run.
+	OutputSuccess = "on_success"
+	// Send the result of a failed run.
+	OutputFailure = "on_failure"
+	// Send the result of every run.
+	OutputComplete = "on_complete"
+	// Send a message when a run passes its readiness checks.
+	OutputReady = "on_ready"
+)
+
+// Outputter is a node with named outputs, in addition to its default output.
+type Outputter interface {
+	// Output answers the source for the named output, or an error if
+	// the node doesn't have it.
+	Output(name string) (Source, error)
+}
+
+// NamedOutput is a named output of a node. Nodes that take it as an
+// input receive only the messages sent on that output.
+type NamedOutput struct {
+	Node Node
+	Name string
+	out  *Channels
+}
+
+func (o *NamedOutput) NewChannel() chan Msg {
+	return o.out.NewChannel()
+}
+
+// outputs are the named outputs of an exec.
+type outputs struct {
+	success  Channels
+	failure  Channels
+	complete Channels
+	ready    Channels
+}
+
+func (o *outputs) get(n Node, name string) (Source, error) {
+	switch name {
+	case OutputSuccess:
+		return &NamedOutput{n, name, &o.success}, nil
+	case OutputFailure:
+		return &NamedOutput{n, name, &o.failure}, nil
+	case OutputComplete:
+		return &NamedOutput{n, name, &o.complete}, nil
+	case OutputReady:
+		return &NamedOutput{n, name, &o.ready}, nil
+	}
+	return nil, errors.New("unknown output \"" + name + "\" (must be " + OutputSuccess + ", " + OutputFailure + ", " + OutputComplete + " or " + OutputReady + ")")
+}
+
+// send() sends the result on the outputs it applies to.
+func (o *outputs) send(msg Msg) {
+	if MsgFailed(msg) {
+		o.failure.SendMsg(msg)
+	} else {
+		o.success.SendMsg(msg)
+	}
+	o.complete.SendMsg(msg)
+}
+
+func (o *outputs) close() {
+	o.success.CloseChannels()
+	o.failure.CloseChannels()
+	o.complete.CloseChannels()
+	o.ready.CloseChannels()
+}
@@ -0,0 +1,210 @@
+package node
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How long a cmd has to become ready, if the exec doesn't say.
+	defaultReadyTimeout = 30 * time.Second
+	// How often the port and url are checked.
+	readyInterval = 250 * time.Millisecond
+)
+
+// errNotWaiting means the run ended before it was ready, which is
+// reported by the run itself.
+var errNotWaiting = errors.New("run ended")
+
+// readyfini is the result of waiting for a run to become ready.
+type readyfini struct {
+	runId int
+	err   error
+	// How long the run took to become ready.
+	elapsed time.Duration
+}
+
+// readyProbe checks that a running cmd is ready: its port accepts
+// connections, its url answers a GET with a 2xx status, and it has
+// written a line matching the output pattern. Only the checks that
+// are set need to pass.
+type readyProbe struct {
+	addr    string
+	url     string
+	output  *regexp.Regexp
+	timeout time.Duration
+}
+
+// newReadyProbe answers the readiness checks of the exec, or nil if it
+// has none. Values that aren't valid are added to the problems, and
+// their checks left out.
+func newReadyProbe(e *Exec, p *problems) *readyProbe {
+	if e.ReadyPort == "" && e.ReadyUrl == "" && e.ReadyOutput == "" {
+		if e.ReadyTimeout != "" {
+			p.add(errors.New("ready_timeout requires ready_port, ready_url or ready_output"))
+		}
+		return nil
+	}
+	r := &readyProbe{timeout: defaultReadyTimeout}
+	if e.ReadyPort != "" {
+		addr := e.ReadyPort
+		if !strings.Contains(addr, ":") {
+			addr = "localhost:" + addr
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			p.check(e.ReadyPort, errors.New("ready_port \""+e.ReadyPort+"\" must be a port or host:port"))
+		} else {
+			r.addr = addr
+		}
+	}
+	if e.ReadyUrl != "" {
+		u, err := url.Parse(e.ReadyUrl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			p.check(e.ReadyUrl, errors.New("ready_url \""+e.ReadyUrl+"\" must be an http or https url"))
+		} else {
+			r.url = e.ReadyUrl
+		}
+	}
+	if e.ReadyOutput != "" {
+		re, err := regexp.Compile(e.ReadyOutput)
+		if err != nil {
+			p.check(e.ReadyOutput, errors.New("ready_output "+err.Error()))
+		} else {
+			r.output = re
+		}
+	}
+	if e.ReadyTimeout != "" {
+		d, err := time.ParseDuration(e.ReadyTimeout)
+		if err != nil || d <= 0 {
+			p.check(e.ReadyTimeout, errors.New("ready_timeout \""+e.ReadyTimeout+"\" must be a duration, i.e. \"30s\""))
+		} else {
+			r.timeout = d
+		}
+	}
+	return r
+}
+
+// wait() waits until the run is ready, answering an error if it isn't
+// within the timeout, or errNotWaiting if the run is stopped or ends first.
+func (r *readyProbe) wait(ctl *runControl, matched <-chan struct{}) error {
+	timer := time.NewTimer(r.timeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(readyInterval)
+	defer ticker.Stop()
+	for {
+		if matched == nil || isClosed(matched) {
+			matched = nil
+			if r.portReady() && r.urlReady() {
+				return nil
+			}
+		}
+		select {
+		case <-ctl.stop:
+			return errNotWaiting
+		case <-ctl.done:
+			return errNotWaiting
+		case <-timer.C:
+			return errors.New("not ready after " + r.timeout.String())
+		case <-ticker.C:
+		case <-matched:
+		}
+	}
+}
+
+func (r *readyProbe) portReady() bool {
+	if r.addr == "" {
+		return true
+	}
+	conn, err := net.DialTimeout("tcp", r.addr, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func (r *readyProbe) urlReady() bool {
+	if r.url == "" {
+		return true
+	}
+	client := http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(r.url)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// lineMatcher closes its channel when a line written to it matches the pattern.
+type lineMatcher struct {
+	mutex   sync.Mutex
+	re      *regexp.Regexp
+	line    []byte
+	matched chan struct{}
+}
+
+func newLineMatcher(re *regexp.Regexp) *lineMatcher {
+	return &lineMatcher{re: re, matched: make(chan struct{})}
+}
+
+func (m *lineMatcher) Write(p []byte) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.re == nil {
+		return len(p), nil
+	}
+	m.line = append(m.line, p...)
+	for {
+		i := bytes.IndexByte(m.line, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimRight(m.line[:i], "\r")
+		m.line = m.line[i+1:]
+		if m.re.Match(line) {
+			m.done()
+			return len(p), nil
+		}
+	}
+	// A partial line can match too, i.e. a prompt.
+	if m.re.Match(m.line) {
+		m.done()
+	} else if len(m.line) > maxTailBytes {
+		m.line = m.line[len(m.line)-maxTailBytes:]
+	}
+	return len(p), nil
+}
+
+// done() stops matching, once a line has matched.
+func (m *lineMatcher) done() {
+	m.re = nil
+	m.line = nil
+	close(m.matched)
+}
+
+// readyMsg answers the message sent when a run becomes ready.
+func readyMsg(name string, runId int, elapsed time.Duration) Msg {
+	var m Msg
+	m.SetString(StatusKey, StatusReady)
+	m.SetDuration(DurationKey, elapsed)
+	m.SetInt(RunIdKey, runId)
+	m.SetString(NodeKey, name)
+	return m
+}
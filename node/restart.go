@@ -0,0 +1,129 @@
+package node
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// The restart policy, if an exec doesn't specify one.
+const (
+	defaultRestartDelay    = 500 * time.Millisecond
+	defaultRestartMaxDelay = 30 * time.Second
+	defaultMaxRestarts     = 5
+	defaultRestartWindow   = time.Minute
+	defaultHealthyUptime   = 10 * time.Second
+)
+
+// restartPolicy decides when an exec with rerun restarts its cmd. A cmd
+// that exits after running for the healthy uptime restarts right away.
+// Otherwise it's restarting too quickly, so it waits, doubling the delay
+// each time, and if it restarts more than the max times within the window
+// it gives up until the exec is triggered again.
+type restartPolicy struct {
+	minDelay    time.Duration
+	maxDelay    time.Duration
+	maxRestarts int
+	window      time.Duration
+	healthy     time.Duration
+
+	delay time.Duration
+	// The times of recent quick restarts.
+	restarts []time.Time
+	timer    *time.Timer
+}
+
+// newRestartPolicy answers the restart policy of the exec. Values that
+// aren't valid are added to the problems, and the default used instead.
+func newRestartPolicy(e *Exec, p *problems) *restartPolicy {
+	r := &restartPolicy{}
+	duration := func(name, s string, def time.Duration) time.Duration {
+		if s == "" {
+			return def
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			p.check(s, errors.New(name+" \""+s+"\" must be a duration, i.e. \"10s\""))
+			return def
+		}
+		return d
+	}
+	r.minDelay = duration("restart_delay", e.RestartDelay, defaultRestartDelay)
+	r.maxDelay = duration("restart_max_delay", e.RestartMaxDelay, defaultRestartMaxDelay)
+	r.window = duration("restart_window", e.RestartWindow, defaultRestartWindow)
+	r.healthy = duration("healthy_uptime", e.HealthyUptime, defaultHealthyUptime)
+	r.maxRestarts = defaultMaxRestarts
+	if e.MaxRestarts != "" {
+		n, err := strconv.Atoi(e.MaxRestarts)
+		if err != nil || n < 0 {
+			p.check(e.MaxRestarts, errors.New("max_restarts \""+e.MaxRestarts+"\" must be a number, 0 for no limit"))
+		} else {
+			r.maxRestarts = n
+		}
+	}
+	if r.maxDelay < r.minDelay {
+		r.maxDelay = r.minDelay
+	}
+	r.reset()
+	return r
+}
+
+// reset() forgets any previous restarts.
+func (r *restartPolicy) reset() {
+	r.cancel()
+	r.delay = r.minDelay
+	r.restarts = nil
+}
+
+// next() answers how long to wait before restarting a cmd that ran for
+// the uptime, or false if it's restarted too often and should give up.
+func (r *restartPolicy) next(now time.Time, uptime time.Duration) (time.Duration, bool) {
+	if uptime >= r.healthy {
+		r.reset()
+		return 0, true
+	}
+	recent := r.restarts[:0]
+	for _, t := range r.restarts {
+		if now.Sub(t) < r.window {
+			recent = append(recent, t)
+		}
+	}
+	r.restarts = append(recent, now)
+	if r.maxRestarts > 0 && len(r.restarts) > r.maxRestarts {
+		return 0, false
+	}
+	delay := r.delay
+	r.delay *= 2
+	if r.delay > r.maxDelay {
+		r.delay = r.maxDelay
+	}
+	return delay, true
+}
+
+// schedule() starts waiting to restart.
+func (r *restartPolicy) schedule(delay time.Duration) {
+	r.cancel()
+	r.timer = time.NewTimer(delay)
+}
+
+// cancel() stops waiting to restart.
+func (r *restartPolicy) cancel() {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+}
+
+// fired() is called when the wait to restart is over.
+func (r *restartPolicy) fired() {
+	r.timer = nil
+}
+
+// wait() answers a channel that fires when it's time to restart,
+// or nil if no restart is scheduled.
+func (r *restartPolicy) wait() <-chan time.Time {
+	if r.timer == nil {
+		return nil
+	}
+	return r.timer.C
+}
@@ -0,0 +1,77 @@
+package node
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewRestartPolicy(t *testing.T) {
+	r := newRestartPolicy(&Exec{}, nil)
+	if r.minDelay != defaultRestartDelay || r.maxDelay != defaultRestartMaxDelay || r.maxRestarts != defaultMaxRestarts ||
+		r.window != defaultRestartWindow || r.healthy != defaultHealthyUptime {
+		t.Errorf("newRestartPolicy() defaults = %+v", r)
+	}
+
+	r = newRestartPolicy(&Exec{RestartDelay: "2s", RestartMaxDelay: "1s", MaxRestarts: "0"}, nil)
+	if r.minDelay != 2*time.Second || r.maxDelay != 2*time.Second || r.maxRestarts != 0 {
+		t.Errorf("newRestartPolicy() = %+v, want the max delay raised to the delay", r)
+	}
+
+	cases := []struct {
+		e    Exec
+		errs int
+	}{
+		{e: Exec{RestartDelay: "1s", RestartMaxDelay: "1m", MaxRestarts: "3", RestartWindow: "1h", HealthyUptime: "0s"}},
+		{e: Exec{RestartDelay: "soon"}, errs: 1},
+		{e: Exec{RestartMaxDelay: "-1s"}, errs: 1},
+		{e: Exec{MaxRestarts: "-1"}, errs: 1},
+		{e: Exec{MaxRestarts: "many", RestartWindow: "1", HealthyUptime: "x"}, errs: 3},
+	}
+	for _, c := range cases {
+		p := &problems{}
+		newRestartPolicy(&c.e, p)
+		if len(p.errs) != c.errs {
+			t.Errorf("newRestartPolicy(%+v) problems = %v, want %v", c.e, p.errs, c.errs)
+		}
+	}
+}
+
+func TestRestartPolicyNext(t *testing.T) {
+	r := newRestartPolicy(&Exec{RestartDelay: "1s", RestartMaxDelay: "3s", MaxRestarts: "3", RestartWindow: "1m", HealthyUptime: "10s"}, nil)
+	now := time.Now()
+	steps := []struct {
+		after  time.Duration
+		uptime time.Duration
+		delay  time.Duration
+		ok     bool
+	}{
+		// Quick exits back off, up to the max delay.
+		{0, time.Second, time.Second, true},
+		{time.Second, time.Second, 2 * time.Second, true},
+		{time.Second, time.Second, 3 * time.Second, true},
+		// The fourth quick restart within the window gives up.
+		{time.Second, time.Second, 0, false},
+		// Once the earlier restarts are outside the window, it restarts again.
+		{time.Minute, time.Second, 3 * time.Second, true},
+		// A healthy run starts over.
+		{time.Second, 10 * time.Second, 0, true},
+		{time.Second, time.Second, time.Second, true},
+	}
+	for i, s := range steps {
+		now = now.Add(s.after)
+		delay, ok := r.next(now, s.uptime)
+		if delay != s.delay || ok != s.ok {
+			t.Errorf("step %v: next() = %v %v, want %v %v", i+1, delay, ok, s.delay, s.ok)
+		}
+	}
+}
+
+func TestRestartPolicyNoLimit(t *testing.T) {
+	r := newRestartPolicy(&Exec{MaxRestarts: "0"}, nil)
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		if _, ok := r.next(now, 0); !ok {
+			t.Fatalf("next() gave up after %v restarts with no limit", i)
+		}
+	}
+}
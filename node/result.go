@@ -0,0 +1,168 @@
+package node
+
+import (
+	"bytes"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// The keys of the values in the message an exec sends when its cmd finishes.
+const (
+	// StatusKey is a string, StatusSuccess, StatusFailure or StatusReady.
+	StatusKey = "status"
+	// ExitCodeKey is an int, the exit code of the cmd, or -1 if it didn't
+	// exit normally (it couldn't start, was killed or timed out).
+	ExitCodeKey = "exit_code"
+	// DurationKey is a time.Duration, how long the cmd ran, or how long
+	// it took to become ready.
+	DurationKey = "duration"
+	// RunIdKey is an int, which counts the runs of the exec.
+	RunIdKey = "run_id"
+	// NodeKey is a string, the name of the exec in the graph, i.e.
+	// "backend.build" for a build in the backend include.
+	NodeKey = "node"
+	// ErrorKey is a string, why the cmd failed. Only set on failure.
+	ErrorKey = "error"
+	// TimedOutKey is a bool, true if the cmd was stopped because it ran
+	// longer than its timeout. Only set on failure.
+	TimedOutKey = "timed_out"
+	// StdoutKey and StderrKey are strings, the last lines the cmd wrote.
+	// Only set if the exec captures output.
+	StdoutKey = "stdout"
+	StderrKey = "stderr"
+	// StepsKey is a []StepResult, one for each step of the run. An exec
+	// without steps has a single step, its cmd.
+	StepsKey = "steps"
+)
+
+// The values of StatusKey. StatusReady is sent when a running cmd
+// passes its readiness checks, see readyProbe.
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+	StatusReady   = "ready"
+)
+
+// The most output kept for a captured tail, no matter how long the lines are.
+const maxTailBytes = 64 * 1024
+
+// Result is the outcome of a single run of an exec's cmd.
+type Result struct {
+	Node     string
+	RunId    int
+	Err      error
+	ExitCode int
+	Duration time.Duration
+	Stdout   string
+	Stderr   string
+	Steps    []StepResult
+}
+
+// StepResult is the outcome of a single step of a run.
+type StepResult struct {
+	Cmd      string
+	Err      error
+	ExitCode int
+	Duration time.Duration
+	// True if the step didn't run, because an earlier step failed
+	// or the run was stopped.
+	Skipped bool
+}
+
+// newResult answers the result of a run that ended with the error.
+func newResult(name string, runId int, err error, duration time.Duration) Result {
+	return Result{Node: name, RunId: runId, Err: err, ExitCode: exitCode(err), Duration: duration}
+}
+
+// exitCode answers the exit code of a cmd that ended with the error.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// Succeeded answers true if the cmd exited with a 0 exit code.
+func (r Result) Succeeded() bool {
+	return r.Err == nil
+}
+
+// TimedOut answers true if the cmd was stopped because it ran longer than its timeout.
+func (r Result) TimedOut() bool {
+	_, ok := r.Err.(timeoutError)
+	return ok
+}
+
+func (r Result) AsMsg() Msg {
+	var m Msg
+	if r.Succeeded() {
+		m.SetString(StatusKey, StatusSuccess)
+	} else {
+		m.SetString(StatusKey, StatusFailure)
+		m.SetString(ErrorKey, r.Err.Error())
+		m.SetBool(TimedOutKey, r.TimedOut())
+	}
+	m.SetInt(ExitCodeKey, r.ExitCode)
+	m.SetDuration(DurationKey, r.Duration)
+	m.SetInt(RunIdKey, r.RunId)
+	m.SetString(NodeKey, r.Node)
+	if r.Stdout != "" {
+		m.SetString(StdoutKey, r.Stdout)
+	}
+	if r.Stderr != "" {
+		m.SetString(StderrKey, r.Stderr)
+	}
+	if len(r.Steps) > 0 {
+		m.Values[StepsKey] = r.Steps
+	}
+	return m
+}
+
+// MsgFailed answers true if the message is the result of a failed run.
+func MsgFailed(m Msg) bool {
+	return m.MustGetString(StatusKey) == StatusFailure
+}
+
+// outputTail keeps the last lines written to it.
+type outputTail struct {
+	mutex sync.Mutex
+	lines int
+	buf   []byte
+}
+
+func newOutputTail(lines int) *outputTail {
+	return &outputTail{lines: lines}
+}
+
+func (t *outputTail) Write(p []byte) (int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.buf = append(t.buf, p...)
+	// Drop everything before the last lines, ignoring a trailing newline.
+	end := bytes.TrimRight(t.buf, "\n")
+	n := len(end)
+	for i := 0; i < t.lines && n >= 0; i++ {
+		n = bytes.LastIndexByte(end[:n], '\n')
+	}
+	if n >= 0 {
+		t.buf = t.buf[n+1:]
+	}
+	if len(t.buf) > maxTailBytes {
+		t.buf = t.buf[len(t.buf)-maxTailBytes:]
+	}
+	return len(p), nil
+}
+
+// String answers the lines, without a trailing newline.
+func (t *outputTail) String() string {
+	if t == nil {
+		return ""
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return string(bytes.TrimRight(t.buf, "\n"))
+}
@@ -0,0 +1,62 @@
+package node
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Step is one of the cmds of an exec that runs several in order. The
+// steps share the exec's environment, shell mode, stop policy, timeout
+// and output.
+type Step struct {
+	Cmd     string `xml:"cmd,attr"`
+	Args    string `xml:"args,attr"`
+	ArgList []Argt `xml:"arg"`
+	// The working directory, by default the dir of the exec.
+	Dir string `xml:"dir,attr"`
+	// When true, a failure of the step doesn't stop the run or fail the exec.
+	ContinueOnError bool `xml:"continue_on_error,attr"`
+}
+
+// describe() answers the cmd and args of the step for messages.
+func (s Step) describe() string {
+	return strings.TrimSpace(s.Cmd + " " + s.Args)
+}
+
+// validateSteps adds a problem if the exec mixes a cmd with steps,
+// and for each step that can't run.
+func validateSteps(e *Exec, p *problems) {
+	if len(e.StepList) <= 0 {
+		return
+	}
+	if e.Cmd != "" || e.Args != "" || len(e.ArgList) > 0 {
+		p.add(errors.New("cmd and args can't be used with <step>, add them as another step"))
+	}
+	for i, s := range e.StepList {
+		if s.Cmd == "" {
+			p.add(errors.New("step " + strconv.Itoa(i+1) + " requires a cmd"))
+		}
+		if !e.Shell {
+			if _, err := splitArgs(s.Args); err != nil {
+				p.check(s.Args, errors.New("step "+strconv.Itoa(i+1)+" "+err.Error()))
+			}
+		}
+	}
+}
+
+// steps() answers the cmds the exec runs: its steps, or its cmd as
+// the only step.
+func (e *Exec) steps() []Step {
+	if len(e.StepList) <= 0 {
+		return []Step{{Cmd: e.Cmd, Args: e.Args, ArgList: e.ArgList, Dir: e.Dir}}
+	}
+	steps := make([]Step, len(e.StepList))
+	for i, s := range e.StepList {
+		if s.Dir == "" {
+			s.Dir = e.Dir
+		}
+		steps[i] = s
+	}
+	return steps
+}
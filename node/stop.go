@@ -0,0 +1,68 @@
+package node
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// The signal sent to stop a process, if none is specified.
+	defaultStopSignal = "SIGTERM"
+	// How long a process has to exit after the stop signal before it's killed.
+	defaultStopTimeout = 5 * time.Second
+)
+
+// stopSignals are the signals that can be used to stop a process.
+var stopSignals = []string{"SIGTERM", "SIGINT", "SIGHUP", "SIGQUIT", "SIGKILL", "SIGUSR1", "SIGUSR2"}
+
+// parseStopSignal answers the signal name in the form "SIGTERM",
+// accepting any case and no SIG prefix. An empty name is the default.
+func parseStopSignal(name string) (string, error) {
+	if name == "" {
+		return defaultStopSignal, nil
+	}
+	s := strings.ToUpper(name)
+	if !strings.HasPrefix(s, "SIG") {
+		s = "SIG" + s
+	}
+	for _, v := range stopSignals {
+		if v == s {
+			return s, nil
+		}
+	}
+	return "", errors.New("unknown stop_signal \"" + name + "\" (must be one of " + strings.Join(stopSignals, ", ") + ")")
+}
+
+// parseStopTimeout answers the stop timeout, i.e. "10s". An empty value is the default.
+func parseStopTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultStopTimeout, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, errors.New("stop_timeout \"" + s + "\" must be a duration, i.e. \"10s\"")
+	}
+	return d, nil
+}
+
+// parseTimeout answers the longest a cmd can run, i.e. "30s". An empty value is no limit.
+func parseTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, errors.New("timeout \"" + s + "\" must be a duration, i.e. \"30s\"")
+	}
+	return d, nil
+}
+
+// timeoutError is the result of a cmd that ran longer than its timeout.
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e timeoutError) Error() string {
+	return "timed out after " + e.timeout.String()
+}
@@ -0,0 +1,44 @@
+// +build !windows
+
+package node
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+var signals = map[string]syscall.Signal{
+	"SIGTERM": syscall.SIGTERM,
+	"SIGINT":  syscall.SIGINT,
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+}
+
+// startGroup makes the cmd start in its own process group, so it
+// can be stopped along with any processes it starts.
+func startGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalGroup sends the signal to every process in the group.
+func signalGroup(p *os.Process, signal string) error {
+	sig, ok := signals[signal]
+	if !ok {
+		sig = syscall.SIGTERM
+	}
+	return syscall.Kill(-p.Pid, sig)
+}
+
+// killGroup kills every process in the group.
+func killGroup(p *os.Process) {
+	syscall.Kill(-p.Pid, syscall.SIGKILL)
+}
+
+// groupExists answers true if any process in the group is still running.
+func groupExists(p *os.Process) bool {
+	return syscall.Kill(-p.Pid, 0) == nil
+}
@@ -0,0 +1,46 @@
+// +build windows
+
+package node
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+var generateConsoleCtrlEvent = syscall.NewLazyDLL("kernel32.dll").NewProc("GenerateConsoleCtrlEvent")
+
+// startGroup makes the cmd start in its own process group, so it
+// can be stopped along with any processes it starts.
+func startGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// signalGroup sends the signal to every process in the group. Windows
+// doesn't have signals, so anything but SIGKILL is sent as a ctrl-break.
+func signalGroup(p *os.Process, signal string) error {
+	if signal == "SIGKILL" {
+		killGroup(p)
+		return nil
+	}
+	r, _, err := generateConsoleCtrlEvent.Call(syscall.CTRL_BREAK_EVENT, uintptr(p.Pid))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// killGroup kills the process and every process it started.
+func killGroup(p *os.Process) {
+	err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(p.Pid)).Run()
+	if err != nil {
+		p.Kill()
+	}
+}
+
+// groupExists answers true if any process in the group is still running.
+// Windows can't easily tell, and taskkill has already ended the tree.
+func groupExists(p *os.Process) bool {
+	return false
+}
@@ -2,8 +2,10 @@ package node
 
 import (
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"sync"
+	"time"
 )
 
 // > 0 is a valid ID
@@ -90,6 +92,25 @@ type ChangeString interface {
 	ChangeString(s string) string
 }
 
+// Validator is a node that reports every problem with its values. Values
+// that skip answers true for aren't checked, i.e. they have variables that
+// can't be replaced yet.
+type Validator interface {
+	Validate(skip func(string) bool) []error
+}
+
+// FullNamer is a node that's told its name in the graph, which starts
+// with the names of the includes it's in, i.e. "backend.build".
+type FullNamer interface {
+	InheritFullName(name string)
+}
+
+// Releaser is a node whose prepared data holds resources, such as open
+// files. If the graph doesn't start, the data is released instead.
+type Releaser interface {
+	ReleasePrepared(data interface{})
+}
+
 // Node is a single stage in the processing graph.
 type Node interface {
 	GetId() Id
@@ -131,6 +152,43 @@ func (c *Cmd) AsMsg() Msg {
 	return m
 }
 
+// validate() adds a problem for each command with an unknown method.
+func (c *Cmds) validate(p *problems) {
+	for _, cmd := range c.CmdList {
+		if cmd.Method != cmdStop {
+			p.add(errors.New("unknown cmd method \"" + cmd.Method + "\""))
+		}
+	}
+}
+
+// problems collects the problems found while validating a node. A nil
+// problems ignores them, for callers that only want the parsed values.
+type problems struct {
+	// Answers true for values that aren't checked.
+	skip func(string) bool
+	errs []error
+}
+
+// add() adds the problem, if there is one.
+func (p *problems) add(err error) {
+	if p != nil && err != nil {
+		p.errs = append(p.errs, err)
+	}
+}
+
+// check() adds the problem found with the value, unless the value is skipped.
+func (p *problems) check(value string, err error) {
+	if p != nil && p.skip != nil && p.skip(value) {
+		return
+	}
+	p.add(err)
+}
+
+// GetCmds answers the commands sent by the node.
+func (c *Cmds) GetCmds() []Cmd {
+	return c.CmdList
+}
+
 func (c *Cmds) FillIds(get GetId) {
 	for i := 0; i < len(c.CmdList); i++ {
 		cmd := &c.CmdList[i]
@@ -194,6 +252,62 @@ func (m *Msg) GetString(key string) (string, bool) {
 	return s, true
 }
 
+func (m *Msg) SetBool(key string, value bool) error {
+	if m.Values == nil {
+		m.Values = make(map[string]interface{})
+	}
+	m.Values[key] = value
+	return nil
+}
+
+func (m *Msg) MustGetBool(key string) bool {
+	b, _ := m.GetBool(key)
+	return b
+}
+
+func (m *Msg) GetBool(key string) (bool, bool) {
+	if m.Values == nil {
+		return false, false
+	}
+	bi, ok := m.Values[key]
+	if !ok {
+		return false, false
+	}
+	b, ok := bi.(bool)
+	if !ok {
+		return false, false
+	}
+	return b, true
+}
+
+func (m *Msg) SetDuration(key string, value time.Duration) error {
+	if m.Values == nil {
+		m.Values = make(map[string]interface{})
+	}
+	m.Values[key] = value
+	return nil
+}
+
+func (m *Msg) MustGetDuration(key string) time.Duration {
+	d, _ := m.GetDuration(key)
+	return d
+}
+
+func (m *Msg) GetDuration(key string) (time.Duration, bool) {
+	if m.Values == nil {
+		return 0, false
+	}
+	di, ok := m.Values[key]
+	if !ok {
+		return 0, false
+	}
+	d, ok := di.(time.Duration)
+	if !ok {
+		return 0, false
+	}
+	return d, true
+}
+
 func (cs *Channels) Add(c chan Msg) {
 	if c != nil {
 		cs.Out = append(cs.Out, c)
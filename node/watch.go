@@ -45,13 +45,6 @@ func (w *Watch) PrepareToStart(p Prepare, inputs []Source) (interface{}, error)
 		return nil, nil
 	}
 
-	// If we want multiple inputs, we'll need to expand the running
-	// code to handle merging.
-	if len(inputs) != 1 {
-		fmt.Println("node.Watch.Start() must have 1 input (for now)", len(inputs))
-		return nil, errors.New("node.Watch does not support multiple inputs")
-	}
-
 	data := prepareDataWatch{}
 	for _, i := range inputs {
 		data.input.Add(i.NewChannel())
@@ -71,7 +64,7 @@ func (w *Watch) Start(s Start, idata interface{}) error {
 	if !ok {
 		return errors.New("node.Watch no prepareData")
 	}
-	if len(data.input.Out) != 1 {
+	if len(data.input.Out) <= 0 {
 		return errors.New("node.Watch no inputs")
 	}
 	//	fmt.Println("Start watch", w, "ins", len(data.input.Out), "outs", len(w.Out))
@@ -84,6 +77,9 @@ func (w *Watch) Start(s Start, idata interface{}) error {
 
 	done := s.GetDoneChannel()
 	waiter := s.GetDoneWaiter()
+	// Watch doesn't act on its inputs, but it does drain them,
+	// so upstream nodes never block.
+	inputChan := startFanIn(s, data.input)
 	waiter.Add(1)
 	go func(done <-chan struct{}, waiter *sync.WaitGroup, data prepareDataWatch) {
 		defer waiter.Done()
@@ -97,10 +93,7 @@ func (w *Watch) Start(s Start, idata interface{}) error {
 			select {
 			case <-done:
 				return
-			case _, imore := <-data.input.Out[0]:
-				if !imore {
-					return
-				}
+			case <-inputChan:
 			case event := <-watcher.Events:
 				//				fmt.Println("event:", event)
 				if event.Op&fsnotify.Write == fsnotify.Write {
@@ -158,6 +151,9 @@ func newWatchList(root string, _filter string) (watch_list, error) {
 	ans := watch_list{root, make(map[string]bool)}
 
 	visit := func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 		if info.IsDir() && len(filter) < 1 {
 			ans.items[file] = true
 		} else if !info.IsDir() && len(filter) > 0 {